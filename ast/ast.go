@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/atrn0/go-monkey/token"
+	"math/big"
+	"strconv"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // ノードの先頭の位置
+	End() token.Position // ノードの直後の位置
 }
 
 // 文: 値は返さない
@@ -24,6 +28,14 @@ type Expression interface {
 	expressionNode()
 }
 
+// nodeString は n を文字列にする。構文エラーで欠けたノードは空文字列になる
+func nodeString(n Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.String()
+}
+
 type Program struct {
 	Statements []Statement
 }
@@ -35,6 +47,18 @@ func (p *Program) TokenLiteral() string {
 		return ""
 	}
 }
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+func (p *Program) End() token.Position {
+	if n := len(p.Statements); n > 0 {
+		return p.Statements[n-1].End()
+	}
+	return token.Position{}
+}
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -54,11 +78,21 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	return ls.Token.End
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	out.WriteString(nodeString(ls.Name))
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -77,7 +111,14 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
-func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
+func (i *Identifier) String() string {
+	if i == nil {
+		return ""
+	}
+	return i.Value
+}
 
 // return 文
 type ReturnStatement struct {
@@ -87,6 +128,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -109,6 +157,13 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -124,6 +179,42 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+// BigIntegerLiteral は int64 に収まらない整数リテラル
+type BigIntegerLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bl *BigIntegerLiteral) expressionNode()      {}
+func (bl *BigIntegerLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BigIntegerLiteral) String() string       { return bl.Token.Literal }
+func (bl *BigIntegerLiteral) Pos() token.Position  { return bl.Token.Pos }
+func (bl *BigIntegerLiteral) End() token.Position  { return bl.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
+
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode()      {}
+func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) String() string       { return strconv.Quote(sl.Value) }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -133,9 +224,15 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
-	return fmt.Sprintf("(%s%s)", pe.Operator,
-		pe.Right.String())
+	return fmt.Sprintf("(%s%s)", pe.Operator, nodeString(pe.Right))
 }
 
 type InfixExpression struct {
@@ -147,9 +244,46 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
-	return fmt.Sprintf("(%s %s %s)", ie.Left, ie.Operator,
-		ie.Right.String())
+	return fmt.Sprintf("(%s %s %s)", nodeString(ie.Left), ie.Operator, nodeString(ie.Right))
+}
+
+// LogicalExpression は && と || を表す。右辺は必要なときだけ評価される
+type LogicalExpression struct {
+	Token    token.Token
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (le *LogicalExpression) expressionNode()      {}
+func (le *LogicalExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LogicalExpression) Pos() token.Position {
+	if le.Left != nil {
+		return le.Left.Pos()
+	}
+	return le.Token.Pos
+}
+func (le *LogicalExpression) End() token.Position {
+	if le.Right != nil {
+		return le.Right.End()
+	}
+	return le.Token.End
+}
+func (le *LogicalExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", nodeString(le.Left), le.Operator, nodeString(le.Right))
 }
 
 type Boolean struct {
@@ -160,6 +294,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	Token       token.Token // 'if'
@@ -172,11 +308,23 @@ func (i *IfExpression) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *IfExpression) Pos() token.Position { return i.Token.Pos }
+
+func (i *IfExpression) End() token.Position {
+	if i.Alternative != nil {
+		return i.Alternative.End()
+	}
+	if i.Consequence != nil {
+		return i.Consequence.End()
+	}
+	return i.Token.End
+}
+
 func (i *IfExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("if")
-	out.WriteString(i.Condition.String())
+	out.WriteString(nodeString(i.Condition))
 	out.WriteString(" ")
 	out.WriteString(i.Consequence.String())
 
@@ -194,6 +342,19 @@ func (i *IfExpression) expressionNode() {
 type BlockStatement struct {
 	Token      token.Token // '{'
 	Statements []Statement
+	Rbrace     token.Token // '}'
+}
+
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.End.IsValid() {
+		return bs.Rbrace.End
+	}
+	if n := len(bs.Statements); n > 0 {
+		return bs.Statements[n-1].End()
+	}
+	return bs.Token.End
 }
 
 func (bs *BlockStatement) TokenLiteral() string {
@@ -201,6 +362,10 @@ func (bs *BlockStatement) TokenLiteral() string {
 }
 
 func (bs *BlockStatement) String() string {
+	if bs == nil {
+		return ""
+	}
+
 	var out bytes.Buffer
 
 	for _, s := range bs.Statements {
@@ -217,18 +382,26 @@ func (bs *BlockStatement) expressionNode() {
 type FunctionLiteral struct {
 	Token      token.Token // 'fn'
 	Parameters []*Identifier
+	Defaults   []Expression // Defaults[i] は Parameters[i] の既定値。既定値が無ければ nil
+	Rest       *Identifier  // ...rest で受け取る残りの引数。無ければ nil
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
-	params := make([]string, 0, len(fl.Parameters))
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
+	params := ParameterStrings(fl.Parameters, fl.Defaults, fl.Rest)
 
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
@@ -241,23 +414,58 @@ func (fl *FunctionLiteral) String() string {
 
 func (fl *FunctionLiteral) expressionNode() {}
 
+// ParameterStrings は "x", "y = 10", "...rest" のように仮引数を文字列にする
+func ParameterStrings(params []*Identifier, defaults []Expression, rest *Identifier) []string {
+	out := make([]string, 0, len(params)+1)
+	for i, p := range params {
+		if i < len(defaults) && defaults[i] != nil {
+			out = append(out, p.String()+" = "+defaults[i].String())
+		} else {
+			out = append(out, p.String())
+		}
+	}
+	if rest != nil {
+		out = append(out, "..."+rest.String())
+	}
+	return out
+}
+
 type CallExpression struct {
-	Token     token.Token // '('
-	Function  Expression  // Identifier or FunctionLiteral
-	Arguments []Expression
+	Token          token.Token // '('
+	Function       Expression  // Identifier or FunctionLiteral
+	Arguments      []Expression
+	NamedArguments []*NamedArgument // 位置引数の後に続く name: value の引数
+	Rparen         token.Token      // ')'
 }
 
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+
+func (ce *CallExpression) End() token.Position {
+	if ce.Rparen.End.IsValid() {
+		return ce.Rparen.End
+	}
+	return ce.Token.End
+}
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
-	args := make([]string, 0, len(ce.Arguments))
+	args := make([]string, 0, len(ce.Arguments)+len(ce.NamedArguments))
 	for _, a := range ce.Arguments {
+		args = append(args, nodeString(a))
+	}
+	for _, a := range ce.NamedArguments {
 		args = append(args, a.String())
 	}
 
-	out.WriteString(ce.Function.String())
+	out.WriteString(nodeString(ce.Function))
 	out.WriteString("(")
 	out.WriteString(strings.Join(args, ", "))
 	out.WriteString(")")
@@ -266,3 +474,291 @@ func (ce *CallExpression) String() string {
 }
 
 func (ce *CallExpression) expressionNode() {}
+
+// NamedArgument は呼び出しでの limit: 10 のような名前付きの引数
+type NamedArgument struct {
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) TokenLiteral() string { return na.Name.TokenLiteral() }
+func (na *NamedArgument) Pos() token.Position  { return na.Name.Pos() }
+func (na *NamedArgument) End() token.Position {
+	if na.Value != nil {
+		return na.Value.End()
+	}
+	return na.Name.End()
+}
+func (na *NamedArgument) String() string {
+	return fmt.Sprintf("%s: %s", na.Name, nodeString(na.Value))
+}
+
+type ArrayLiteral struct {
+	Token    token.Token // '['
+	Elements []Expression
+	Rbracket token.Token // ']'
+}
+
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+
+func (al *ArrayLiteral) String() string {
+	var out bytes.Buffer
+
+	elements := make([]string, 0, len(al.Elements))
+	for _, el := range al.Elements {
+		elements = append(elements, nodeString(el))
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
+func (al *ArrayLiteral) End() token.Position {
+	if al.Rbracket.End.IsValid() {
+		return al.Rbracket.End
+	}
+	return al.Token.End
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+
+type IndexExpression struct {
+	Token    token.Token // '['
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // ']'
+}
+
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+
+func (ie *IndexExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(nodeString(ie.Left))
+	out.WriteString("[")
+	out.WriteString(nodeString(ie.Index))
+	out.WriteString("])")
+
+	return out.String()
+}
+
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.End.IsValid() {
+		return ie.Rbracket.End
+	}
+	return ie.Token.End
+}
+
+func (ie *IndexExpression) expressionNode() {}
+
+type HashLiteral struct {
+	Token  token.Token // '{'
+	Pairs  map[Expression]Expression
+	Keys   []Expression // ソースに現れた順のキー
+	Rbrace token.Token  // '}'
+}
+
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+
+	pairs := make([]string, 0, len(hl.Keys))
+	for _, key := range hl.Keys {
+		pairs = append(pairs, nodeString(key)+": "+nodeString(hl.Pairs[key]))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.End.IsValid() {
+		return hl.Rbrace.End
+	}
+	return hl.Token.End
+}
+
+func (hl *HashLiteral) expressionNode() {}
+
+// throw 文
+type ThrowStatement struct {
+	Token token.Token // token.THROW
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position {
+	if ts.Value != nil {
+		return ts.Value.End()
+	}
+	return ts.Token.End
+}
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// try { ... } catch (e) { ... } finally { ... }
+// catch と finally はどちらか一方を省略できる
+type TryExpression struct {
+	Token      token.Token // 'try'
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch")
+		if te.CatchParam != nil {
+			out.WriteString("(" + te.CatchParam.String() + ")")
+		}
+		out.WriteString(" ")
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+func (te *TryExpression) Pos() token.Position { return te.Token.Pos }
+
+func (te *TryExpression) End() token.Position {
+	switch {
+	case te.Finally != nil:
+		return te.Finally.End()
+	case te.Catch != nil:
+		return te.Catch.End()
+	case te.Block != nil:
+		return te.Block.End()
+	}
+	return te.Token.End
+}
+
+func (te *TryExpression) expressionNode() {}
+
+// x = 1 や x += 1
+type AssignExpression struct {
+	Token    token.Token // '=' や '+='
+	Name     *Identifier
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Name.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", ae.Name, ae.Operator, nodeString(ae.Value))
+}
+
+// while (cond) { ... }
+type WhileStatement struct {
+	Token     token.Token // 'while'
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+func (ws *WhileStatement) String() string {
+	return fmt.Sprintf("while%s %s", nodeString(ws.Condition), ws.Body)
+}
+
+// for (x in iterable) { ... }
+type ForStatement struct {
+	Token    token.Token // 'for'
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+func (fs *ForStatement) String() string {
+	return fmt.Sprintf("for(%s in %s) %s", fs.Variable, nodeString(fs.Iterable), fs.Body)
+}
+
+// break 文
+type BreakStatement struct {
+	Token token.Token // 'break'
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+// continue 文
+type ContinueStatement struct {
+	Token token.Token // 'continue'
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return "continue;" }
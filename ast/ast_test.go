@@ -35,3 +35,31 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestStringWithMissingNodes(t *testing.T) {
+	// 構文エラーで子が欠けたノードも String で panic しない
+	tests := []struct {
+		node     Node
+		expected string
+	}{
+		{&PrefixExpression{Operator: "-"}, "(-)"},
+		{&InfixExpression{Operator: "+"}, "( + )"},
+		{&LogicalExpression{Operator: "&&"}, "( && )"},
+		{&IndexExpression{}, "([])"},
+		{&CallExpression{Arguments: []Expression{nil}}, "()"},
+		{&IfExpression{}, "if "},
+		{&FunctionLiteral{Token: token.Token{Literal: "fn"}}, "fn()"},
+		{&TryExpression{}, "try "},
+		{&AssignExpression{Operator: "="}, "( = )"},
+		{&WhileStatement{}, "while "},
+		{&ForStatement{}, "for( in ) "},
+		{&LetStatement{Token: token.Token{Literal: "let"}}, "let  = ;"},
+		{&NamedArgument{}, ": "},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.node.String(); actual != tt.expected {
+			t.Errorf("%T.String() wrong. want %q, got %q", tt.node, tt.expected, actual)
+		}
+	}
+}
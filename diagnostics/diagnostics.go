@@ -0,0 +1,269 @@
+// Package diagnostics はエラーをソースコードの該当箇所と一緒に表示する
+package diagnostics
+
+import (
+	"fmt"
+	"github.com/atrn0/go-monkey/object"
+	"github.com/atrn0/go-monkey/parser"
+	"github.com/atrn0/go-monkey/token"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Report は表示する 1 件のエラー
+type Report struct {
+	Severity string // "error" や "warning"
+	Message  string
+	Pos      token.Position
+	End      token.Position // 不明な場合はゼロ値
+	Hint     string
+	Stack    []object.StackFrame // 実行時エラーが伝わってきた関数呼び出し
+	// DroppedFrames は Stack の真ん中で省略済みの呼び出しの数
+	DroppedFrames int
+}
+
+// FromDiagnostic はパーサの診断を Report に変換する
+func FromDiagnostic(d parser.Diagnostic) Report {
+	r := Report{
+		Severity: d.Severity.String(),
+		Message:  d.Message,
+		Pos:      d.Pos,
+		End:      d.End,
+	}
+	if d.Got == token.EOF {
+		r.Hint = "the input ended unexpectedly"
+	}
+	return r
+}
+
+// FromError は実行時エラーを Report に変換する
+func FromError(e *object.Error) Report {
+	return Report{
+		Severity: "error",
+		Message:  e.Message,
+		Pos:      e.Pos,
+		End:      e.End,
+		Stack:    e.Stack,
+
+		DroppedFrames: e.DroppedFrames,
+	}
+}
+
+// maxStackFrames を超えるスタックトレースは先頭と末尾だけを表示する
+const maxStackFrames = 20
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiBlue  = "\x1b[34m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// Renderer はソースコードを保持して Report を表示する
+type Renderer struct {
+	// Color が true の場合は ANSI エスケープシーケンスで色を付ける
+	Color bool
+
+	sources map[string]string
+}
+
+func NewRenderer(color bool) *Renderer {
+	return &Renderer{Color: color, sources: make(map[string]string)}
+}
+
+// AddSource は filename の内容を登録する
+// 登録されていないファイルのエラーはソースコードなしで表示する
+func (r *Renderer) AddSource(filename, source string) {
+	r.sources[filename] = source
+}
+
+// Render は rep を次のような形式で w に書き込む
+//
+//	error: type mismatch: INTEGER + BOOLEAN
+//	 --> script.monkey:2:1
+//	  |
+//	2 | x + true
+//	  | ^^^^^^^^
+//	  = hint: ...
+//	  = stack trace:
+//	      at add(1, true) (script.monkey:4:1)
+func (r *Renderer) Render(w io.Writer, rep Report) {
+	severity := rep.Severity
+	if severity == "" {
+		severity = "error"
+	}
+	fmt.Fprintf(w, "%s: %s\n",
+		r.paint(ansiBold+severityColor(severity), severity),
+		r.paint(ansiBold, rep.Message))
+
+	line, ok := r.sourceLine(rep.Pos)
+	gutter := strings.Repeat(" ", len(strconv.Itoa(rep.Pos.Line)))
+
+	if rep.Pos.IsValid() {
+		fmt.Fprintf(w, "%s%s %s\n", gutter, r.paint(ansiBlue, "-->"), rep.Pos)
+	}
+
+	if ok {
+		bar := r.paint(ansiBlue, "|")
+		fmt.Fprintf(w, "%s %s\n", gutter, bar)
+		fmt.Fprintf(w, "%s %s %s\n", r.paint(ansiBlue, strconv.Itoa(rep.Pos.Line)), bar, line)
+		fmt.Fprintf(w, "%s %s %s\n", gutter, bar, r.underline(line, rep))
+	}
+
+	if rep.Hint != "" {
+		fmt.Fprintf(w, "%s %s %s\n", gutter, r.paint(ansiBlue, "="), r.paint(ansiCyan, "hint: "+rep.Hint))
+	}
+
+	if len(rep.Stack) > 0 {
+		fmt.Fprintf(w, "%s %s stack trace:\n", gutter, r.paint(ansiBlue, "="))
+		r.renderStack(w, gutter, rep.Stack, rep.DroppedFrames)
+	}
+}
+
+func (r *Renderer) renderStack(w io.Writer, gutter string, stack []object.StackFrame, dropped int) {
+	for i := 0; i < len(stack); i++ {
+		if len(stack) > maxStackFrames && i == maxStackFrames/2 {
+			omitted := len(stack) - maxStackFrames
+			fmt.Fprintf(w, "%s     ... %d more frames ...\n", gutter, omitted+dropped)
+			i += omitted
+		}
+		fmt.Fprintf(w, "%s     at %s\n", gutter, stack[i])
+	}
+}
+
+// sourceLine は pos の行を返す
+func (r *Renderer) sourceLine(pos token.Position) (string, bool) {
+	if !pos.IsValid() {
+		return "", false
+	}
+	source, ok := r.sources[pos.Filename]
+	if !ok {
+		return "", false
+	}
+
+	lines := strings.Split(source, "\n")
+	if pos.Line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[pos.Line-1], "\r"), true
+}
+
+// underline は rep の範囲の下に引く線を返す
+// 複数行にわたる範囲は最初の行の終わりまで引く
+// 範囲はバイト単位の列で切り出し、線と字下げは端末での表示幅に合わせる
+func (r *Renderer) underline(line string, rep Report) string {
+	start := byteColumn(rep.Pos) - 1
+	if start > len(line) {
+		start = len(line)
+	}
+
+	end := start + 1
+	if rep.End.IsValid() && rep.End.Line == rep.Pos.Line && byteColumn(rep.End) > byteColumn(rep.Pos) {
+		end = byteColumn(rep.End) - 1
+	} else if rep.End.Line > rep.Pos.Line {
+		end = len(line)
+	}
+	if end > len(line) {
+		end = len(line)
+	}
+
+	// タブの幅がずれないように、ソースの空白をそのまま使う
+	var prefix strings.Builder
+	for _, ch := range line[:start] {
+		if ch == '\t' {
+			prefix.WriteByte('\t')
+		} else {
+			prefix.WriteString(strings.Repeat(" ", runeWidth(ch)))
+		}
+	}
+
+	width := 0
+	for _, ch := range line[start:end] {
+		width += runeWidth(ch)
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	return prefix.String() + r.paint(ansiBold+ansiRed, strings.Repeat("^", width))
+}
+
+// wideRunes は端末で 2 桁の幅で表示される文字 (East Asian Wide と Fullwidth) のおおよその範囲
+var wideRunes = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1}, // ハングル字母
+		{0x2e80, 0x303e, 1}, // CJK 部首、記号と句読点
+		{0x3041, 0x33ff, 1}, // ひらがな、カタカナ、CJK 互換文字
+		{0x3400, 0x4dbf, 1}, // CJK 統合漢字拡張 A
+		{0x4e00, 0x9fff, 1}, // CJK 統合漢字
+		{0xa000, 0xa4cf, 1}, // イ文字
+		{0xac00, 0xd7a3, 1}, // ハングル音節
+		{0xf900, 0xfaff, 1}, // CJK 互換漢字
+		{0xfe30, 0xfe4f, 1}, // CJK 互換形
+		{0xff00, 0xff60, 1}, // 全角英数と記号
+		{0xffe0, 0xffe6, 1}, // 全角記号
+	},
+	R32: []unicode.Range32{
+		{0x1f300, 0x1f64f, 1}, // 絵文字
+		{0x1f900, 0x1f9ff, 1}, // 補助絵文字
+		{0x20000, 0x2fffd, 1}, // CJK 統合漢字拡張 B 以降
+		{0x30000, 0x3fffd, 1},
+	},
+}
+
+// runeWidth は ch を端末で表示したときの桁数を返す
+// 結合文字などの幅の無い文字は 0、全角の文字は 2 になる
+func runeWidth(ch rune) int {
+	switch {
+	case unicode.In(ch, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case unicode.Is(wideRunes, ch):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// byteColumn は pos の行頭からのバイト単位の列を返す
+// ByteColumn が設定されていない位置では Column を使う
+func byteColumn(pos token.Position) int {
+	if pos.ByteColumn > 0 {
+		return pos.ByteColumn
+	}
+	return pos.Column
+}
+
+func (r *Renderer) paint(color, s string) string {
+	if !r.Color {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+func severityColor(severity string) string {
+	if severity == "error" {
+		return ansiRed
+	}
+	return ansiCyan
+}
+
+// ColorEnabled は w に色付きで出力してよいかどうかを返す
+// w が端末で、環境変数 NO_COLOR が設定されていない場合に true になる
+func ColorEnabled(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
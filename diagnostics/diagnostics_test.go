@@ -0,0 +1,182 @@
+package diagnostics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/atrn0/go-monkey/evaluator"
+	"github.com/atrn0/go-monkey/lexer"
+	"github.com/atrn0/go-monkey/object"
+	"github.com/atrn0/go-monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestRenderRuntimeError(t *testing.T) {
+	source := "let x = 1;\nlet y = x + true;\n"
+
+	program := parser.New(lexer.NewFile("test.monkey", source)).ParseProgram()
+	evaluated := evaluator.Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+
+	r := NewRenderer(false)
+	r.AddSource("test.monkey", source)
+
+	var out bytes.Buffer
+	rep := FromError(errObj)
+	rep.Hint = "convert the operands to the same type"
+	r.Render(&out, rep)
+
+	expected := `error: type mismatch: INTEGER + BOOLEAN
+ --> test.monkey:2:9
+  |
+2 | let y = x + true;
+  |         ^^^^^^^^
+  = hint: convert the operands to the same type
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderParseError(t *testing.T) {
+	source := "let f = fn(x) {\n\tx +\n"
+
+	p := parser.New(lexer.New(source))
+	p.ParseProgram()
+	if len(p.Diagnostics()) != 1 {
+		t.Fatalf("expected 1 diagnostic. got %q", p.Errors())
+	}
+
+	r := NewRenderer(false)
+	r.AddSource("", source)
+
+	var out bytes.Buffer
+	r.Render(&out, FromDiagnostic(p.Diagnostics()[0]))
+
+	expected := "error: no prefix parse function for EOF found\n" +
+		" --> 3:1\n" +
+		"  |\n" +
+		"3 | \n" +
+		"  | ^\n" +
+		"  = hint: the input ended unexpectedly\n"
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected:\n%q\ngot:\n%q", expected, out.String())
+	}
+}
+
+func TestRenderUnicode(t *testing.T) {
+	source := "let größe = \"日本\" + größe2;\n"
+
+	program := parser.New(lexer.NewFile("test.monkey", source)).ParseProgram()
+	evaluated := evaluator.Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+
+	r := NewRenderer(false)
+	r.AddSource("test.monkey", source)
+
+	var out bytes.Buffer
+	r.Render(&out, FromError(errObj))
+
+	// 列は文字単位で数え、線は全角の文字を 2 桁として表示幅に合わせて引く
+	expected := `error: identifier not found: größe2
+ --> test.monkey:1:20
+  |
+1 | let größe = "日本" + größe2;
+  |                      ^^^^^^
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"let s = \"日本\"; s + 1", "  |" + strings.Repeat(" ", 17) + "^^^^^\n"},
+		{"let 名前 = 1; 名前 + true", "  |" + strings.Repeat(" ", 15) + "^^^^^^^^^^^\n"},
+		{"let s = \"e\u0301\"; s + 1", "  |" + strings.Repeat(" ", 14) + "^^^^^\n"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.NewFile("test.monkey", tt.source)).ParseProgram()
+		errObj, ok := evaluator.Eval(program, object.NewEnvironment()).(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q", tt.source)
+			continue
+		}
+
+		r.AddSource("test.monkey", tt.source)
+		var out bytes.Buffer
+		r.Render(&out, FromError(errObj))
+		if !strings.HasSuffix(out.String(), tt.expected) {
+			t.Errorf("wrong underline for %q.\nexpected suffix:\n%s\ngot:\n%s", tt.source, tt.expected, out.String())
+		}
+	}
+}
+
+func TestRenderWithoutSource(t *testing.T) {
+	r := NewRenderer(false)
+
+	var out bytes.Buffer
+	r.Render(&out, FromError(&object.Error{Message: "boom"}))
+
+	if out.String() != "error: boom\n" {
+		t.Errorf("wrong output. got %q", out.String())
+	}
+}
+
+func TestRenderColor(t *testing.T) {
+	r := NewRenderer(true)
+	r.AddSource("", "1 + true")
+
+	var out bytes.Buffer
+	r.Render(&out, FromError(evaluator.Eval(
+		parser.New(lexer.New("1 + true")).ParseProgram(), object.NewEnvironment()).(*object.Error)))
+
+	if !strings.Contains(out.String(), ansiBold+ansiRed+"error"+ansiReset) {
+		t.Errorf("severity is not colored. got %q", out.String())
+	}
+	if !strings.Contains(out.String(), ansiBold+ansiRed+"^^^^^^^^"+ansiReset) {
+		t.Errorf("underline is not colored. got %q", out.String())
+	}
+}
+
+func TestRenderStackTrace(t *testing.T) {
+	source := "let f = fn(n) { if (n == 0) { 1 + true } else { f(n - 1) } };\nf(25)"
+
+	program := parser.New(lexer.New(source)).ParseProgram()
+	errObj := evaluator.Eval(program, object.NewEnvironment()).(*object.Error)
+
+	r := NewRenderer(false)
+	var out bytes.Buffer
+	r.Render(&out, FromError(errObj))
+
+	expected := "error: type mismatch: INTEGER + BOOLEAN\n" +
+		" --> 1:31\n" +
+		"  = stack trace:\n"
+	for n := 0; n < 10; n++ {
+		expected += fmt.Sprintf("      at f(%d) (1:49)\n", n)
+	}
+	expected += "      ... 6 more frames ...\n"
+	for n := 16; n < 25; n++ {
+		expected += fmt.Sprintf("      at f(%d) (1:49)\n", n)
+	}
+	expected += "      at f(25) (2:1)\n"
+
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+	// 評価器が記録しきれずに捨てた呼び出しも省略した数に含める
+	errObj.DroppedFrames = 100
+	out.Reset()
+	r.Render(&out, FromError(errObj))
+	if !strings.Contains(out.String(), "      ... 106 more frames ...\n") {
+		t.Errorf("dropped frames not counted. got:\n%s", out.String())
+	}
+}
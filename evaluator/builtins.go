@@ -0,0 +1,221 @@
+package evaluator
+
+import (
+	"fmt"
+	"github.com/atrn0/go-monkey/object"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+)
+
+var (
+	builtinsMu sync.RWMutex
+	builtins   = map[string]*object.Builtin{}
+)
+
+// RegisterBuiltin は name で呼び出せる組み込み関数を登録する
+// 同じ名前の組み込み関数は上書きされる。評価中の別の goroutine から呼んでもよいが、
+// 評価中のプログラムから新しい組み込み関数が見えるかどうかは決まらない
+func RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	builtinsMu.Lock()
+	defer builtinsMu.Unlock()
+	builtins[name] = &object.Builtin{Name: name, Fn: fn}
+}
+
+// LookupBuiltin は name で登録された組み込み関数を返す
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtinsMu.RLock()
+	defer builtinsMu.RUnlock()
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// putsBuiltin は評価ごとの Config.Stdout に書き込むので、applyFunction が評価から呼び出す
+// Fn は評価の外から直接呼ばれた場合のために os.Stdout に書き込む
+var putsBuiltin = &object.Builtin{
+	Name: "puts",
+	Fn: func(args ...object.Object) object.Object {
+		return builtinPuts(os.Stdout, args)
+	},
+}
+
+// stdout は puts の出力先を返す
+func (ev *evaluation) stdout() io.Writer {
+	if ev.config.Stdout != nil {
+		return ev.config.Stdout
+	}
+	return os.Stdout
+}
+
+func init() {
+	RegisterBuiltin("len", builtinLen)
+	builtins["puts"] = putsBuiltin
+	RegisterBuiltin("first", builtinFirst)
+	RegisterBuiltin("last", builtinLast)
+	RegisterBuiltin("rest", builtinRest)
+	RegisterBuiltin("push", builtinPush)
+	RegisterBuiltin("type", builtinType)
+	RegisterBuiltin("range", builtinRange)
+}
+
+func builtinLen(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newBuiltinError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.String:
+		return &object.Integer{Value: int64(len(arg.Value))}
+	case *object.Array:
+		return &object.Integer{Value: int64(len(arg.Elements))}
+	case *object.Hash:
+		return &object.Integer{Value: int64(len(arg.Pairs))}
+	case *object.Range:
+		// 要素数が int64 に収まらない range は他の整数と同じく BigInt になる
+		return object.NewInteger(new(big.Int).SetUint64(arg.Len()))
+	default:
+		return newBuiltinError(object.TYPE_ERROR, "argument to `len` not supported, got %s", args[0].Type())
+	}
+}
+
+func builtinPuts(w io.Writer, args []object.Object) object.Object {
+	for _, arg := range args {
+		fmt.Fprintln(w, arg.Inspect())
+	}
+
+	return NULL
+}
+
+func builtinFirst(args ...object.Object) object.Object {
+	arr, err := arrayArgument("first", args)
+	if err != nil {
+		return err
+	}
+
+	if len(arr.Elements) > 0 {
+		return arr.Elements[0]
+	}
+
+	return NULL
+}
+
+func builtinLast(args ...object.Object) object.Object {
+	arr, err := arrayArgument("last", args)
+	if err != nil {
+		return err
+	}
+
+	if length := len(arr.Elements); length > 0 {
+		return arr.Elements[length-1]
+	}
+
+	return NULL
+}
+
+func builtinRest(args ...object.Object) object.Object {
+	arr, err := arrayArgument("rest", args)
+	if err != nil {
+		return err
+	}
+
+	length := len(arr.Elements)
+	if length == 0 {
+		return NULL
+	}
+
+	newElements := make([]object.Object, length-1)
+	copy(newElements, arr.Elements[1:length])
+	return &object.Array{Elements: newElements}
+}
+
+func builtinPush(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newBuiltinError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+	if args[0].Type() != object.ARRAY_OBJ {
+		return newBuiltinError(object.TYPE_ERROR, "argument to `push` must be ARRAY, got %s", args[0].Type())
+	}
+
+	arr := args[0].(*object.Array)
+	length := len(arr.Elements)
+
+	newElements := make([]object.Object, length+1)
+	copy(newElements, arr.Elements)
+	newElements[length] = args[1]
+
+	return &object.Array{Elements: newElements}
+}
+
+func builtinType(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newBuiltinError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	return &object.String{Value: string(args[0].Type())}
+}
+
+// builtinRange は range(end), range(start, end), range(start, end, step) を返す
+func builtinRange(args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newBuiltinError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1..3", len(args))
+	}
+
+	values := make([]int64, 0, len(args))
+	for _, arg := range args {
+		value, err := int64Argument("range", arg)
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+
+	r := &object.Range{Step: 1}
+	switch len(values) {
+	case 1:
+		r.End = values[0]
+	case 2:
+		r.Start, r.End = values[0], values[1]
+	case 3:
+		r.Start, r.End, r.Step = values[0], values[1], values[2]
+	}
+
+	if r.Step == 0 {
+		return newBuiltinError(object.ARGUMENT_ERROR, "range step must not be zero")
+	}
+
+	return r
+}
+
+// int64Argument は整数の引数を int64 として返す
+// int64 に収まらない任意精度の整数は OVERFLOW_ERROR にする
+func int64Argument(name string, arg object.Object) (int64, *object.Error) {
+	switch arg := arg.(type) {
+	case *object.Integer:
+		return arg.Value, nil
+	case *object.BigInt:
+		return 0, newBuiltinError(object.OVERFLOW_ERROR, "argument to `%s` out of range: integer does not fit in 64 bits", name)
+	default:
+		return 0, newBuiltinError(object.TYPE_ERROR, "argument to `%s` must be INTEGER, got %s", name, arg.Type())
+	}
+}
+
+// arrayArgument は引数が配列 1 つだけであることを確認する
+func arrayArgument(name string, args []object.Object) (*object.Array, *object.Error) {
+	if len(args) != 1 {
+		return nil, newBuiltinError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, newBuiltinError(object.TYPE_ERROR, "argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+
+	return arr, nil
+}
+
+// newBuiltinError は位置情報を持たないエラーを返す
+// 位置は applyFunction で呼び出し元のものが設定される
+func newBuiltinError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
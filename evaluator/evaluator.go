@@ -1,82 +1,254 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/atrn0/go-monkey/ast"
 	"github.com/atrn0/go-monkey/object"
+	"math"
+	"math/big"
+	"strings"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// evaluation は 1 回の評価全体で共有される状態
+type evaluation struct {
+	ctx    context.Context
+	done   <-chan struct{}
+	config Config
+
+	depth       int
+	nesting     int
+	steps       int
+	allocations int
+
+	// current は評価中のノード。panic から回復したときのエラーの位置に使う
+	current ast.Node
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return EvalContext(context.Background(), node, env)
+}
+
+// EvalContext は ctx がキャンセルされるかタイムアウトすると評価を中断し、
+// Err に ctx.Err() を持つ *object.Error を返す
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	return EvalWithConfig(ctx, node, env, Config{})
+}
+
+// EvalWithConfig は config の制限の下で評価する
+// 制限を超えると評価を中断して *object.Error を返す
+// 評価中に Go の panic が起きた場合は回復し、評価中だったノードの位置の INTERNAL_ERROR を返す
+func EvalWithConfig(ctx context.Context, node ast.Node, env *object.Environment, config Config) (result object.Object) {
+	ev := &evaluation{ctx: ctx, done: ctx.Done(), config: config, current: node}
+	defer func() {
+		if r := recover(); r != nil {
+			err := newError(ev.current, object.INTERNAL_ERROR, "internal error: %v", r)
+			err.Err = fmt.Errorf("panic: %v", r)
+			result = err
+		}
+	}()
+	return ev.eval(node, env)
+}
+
+// IsCanceled は obj が context のキャンセルによって中断されたことを表すエラーかどうかを返す
+func IsCanceled(obj object.Object) bool {
+	err, ok := obj.(*object.Error)
+	return ok && (errors.Is(err.Err, context.Canceled) || errors.Is(err.Err, context.DeadlineExceeded))
+}
+
+// checkCanceled は ctx がキャンセルされていれば node の位置のエラーを返す
+func (ev *evaluation) checkCanceled(node ast.Node) *object.Error {
+	if ev.done == nil {
+		return nil
+	}
+
+	select {
+	case <-ev.done:
+		err := newError(node, object.CANCELED_ERROR, "execution canceled: %s", ev.ctx.Err())
+		err.Err = ev.ctx.Err()
+		return err
+	default:
+		return nil
+	}
+}
+
+// eval は node を評価する。遅くなるので defer は使わない
+// panic した場合は current とネストが戻らないが、その評価は EvalWithConfig で打ち切られる
+func (ev *evaluation) eval(node ast.Node, env *object.Environment) object.Object {
+	if node == nil {
+		return ev.missingNodeError()
+	}
+	if err := ev.enterNode(node); err != nil {
+		ev.leaveNode()
+		return err
+	}
+
+	parent := ev.current
+	ev.current = node
+	result := ev.evalNode(node, env)
+	ev.current = parent
+
+	ev.leaveNode()
+	return result
+}
+
+// missingNodeError は構文エラーで欠けたノードを評価しようとしたときのエラーを返す
+// 構文エラーのあるプログラムの AST を Eval に渡された場合に起きる
+func (ev *evaluation) missingNodeError() *object.Error {
+	if ev.current == nil {
+		return &object.Error{Kind: object.SYNTAX_ERROR, Message: "missing expression"}
+	}
+	return newError(ev.current, object.SYNTAX_ERROR, "missing expression")
+}
+
+func (ev *evaluation) evalNode(node ast.Node, env *object.Environment) object.Object {
+	if err := ev.step(node); err != nil {
+		return err
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node, env)
+		return ev.evalProgram(node, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return ev.eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return ev.evalBigIntegerLiteral(node)
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+	case *ast.StringLiteral:
+		return ev.alloc(node, &object.String{Value: node.Value})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		if literal, ok := node.Right.(*ast.BigIntegerLiteral); ok && node.Operator == "-" {
+			return ev.evalNegatedBigIntegerLiteral(node, literal)
+		}
+		right := ev.eval(node.Right, env)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return ev.evalPrefixExpression(node, node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := ev.eval(node.Left, env)
+		if isAbrupt(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := ev.eval(node.Right, env)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return ev.alloc(node, ev.evalInfixExpression(node, node.Operator, left, right))
+	case *ast.LogicalExpression:
+		return ev.evalLogicalExpression(node, env)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return ev.evalBlockStatement(node, env)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return ev.evalIfExpression(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := ev.eval(node.ReturnValue, env)
+		if isAbrupt(val) {
+			return val
+		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := ev.eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return newThrownError(node, val)
+	case *ast.TryExpression:
+		return ev.evalTryExpression(node, env)
+	case *ast.WhileStatement:
+		return ev.evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return ev.evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := ev.eval(node.Value, env)
+		if isAbrupt(val) {
 			return val
 		}
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+			fn.Name = node.Name.Value
+		}
 		env.Set(node.Name.Value, val)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
+	case *ast.AssignExpression:
+		return ev.evalAssignExpression(node, env)
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return ev.alloc(node, &object.Function{
+			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Body:       node.Body,
+			Env:        env,
+		})
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
+		function := ev.eval(node.Function, env)
+		if isAbrupt(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := ev.evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		named := make([]namedArgument, 0, len(node.NamedArguments))
+		for _, arg := range node.NamedArguments {
+			value := ev.eval(arg.Value, env)
+			if isAbrupt(value) {
+				return value
+			}
+			named = append(named, namedArgument{node: arg, value: value})
+		}
+		return ev.applyFunction(node, function, args, named)
+	case *ast.ArrayLiteral:
+		elements := ev.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
+			return elements[0]
+		}
+		return ev.alloc(node, &object.Array{Elements: elements})
+	case *ast.IndexExpression:
+		left := ev.eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := ev.eval(node.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		return evalIndexExpression(node, left, index)
+	case *ast.HashLiteral:
+		return ev.alloc(node, ev.evalHashLiteral(node, env))
 	}
 
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (ev *evaluation) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		if err := ev.checkCanceled(stmt); err != nil {
+			return err
+		}
+
+		result = ev.eval(stmt, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -89,15 +261,16 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (ev *evaluation) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
+		if err := ev.checkCanceled(stmt); err != nil {
+			return err
+		}
 
-		switch result.(type) {
-		case *object.Error:
-		case *object.ReturnValue:
+		result = ev.eval(stmt, env)
+		if isAbrupt(result) {
 			return result
 		}
 	}
@@ -105,6 +278,129 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// evalLogicalExpression は左辺で結果が決まれば右辺を評価せずに左辺を返す
+// 結果は真偽値に変換せず、決め手になった方の値をそのまま返す
+func (ev *evaluation) evalLogicalExpression(le *ast.LogicalExpression, env *object.Environment) object.Object {
+	left := ev.eval(le.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+
+	switch le.Operator {
+	case "&&":
+		if !isTruthy(left) {
+			return left
+		}
+	case "||":
+		if isTruthy(left) {
+			return left
+		}
+	default:
+		return newError(le, object.TYPE_ERROR, "unknown operator: %s", le.Operator)
+	}
+
+	return ev.eval(le.Right, env)
+}
+
+func (ev *evaluation) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		if err := ev.checkCanceled(ws); err != nil {
+			return err
+		}
+
+		condition := ev.eval(ws.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := ev.eval(ws.Body, env)
+		if signal, stop := loopSignal(result); stop {
+			return signal
+		}
+	}
+}
+
+func (ev *evaluation) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := ev.eval(fs.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+
+	var result object.Object = NULL
+	err := iterate(iterable, func(elem object.Object) bool {
+		if err := ev.checkCanceled(fs); err != nil {
+			result = err
+			return false
+		}
+
+		// 繰り返しごとに新しいスコープを作り、クロージャがそれぞれの値を捕まえられるようにする
+		iterEnv := object.NewEncloseEnv(env)
+		iterEnv.Set(fs.Variable.Value, elem)
+
+		if signal, stop := loopSignal(ev.eval(fs.Body, iterEnv)); stop {
+			result = signal
+			return false
+		}
+		return true
+	})
+	if err {
+		return newError(fs.Iterable, object.TYPE_ERROR, "not iterable: %s", iterable.Type())
+	}
+
+	return result
+}
+
+// loopSignal はループの本体を評価した結果から、ループを終えるかどうかを返す
+// break はループの値として NULL にし、return とエラーはそのまま外に伝える
+func loopSignal(result object.Object) (object.Object, bool) {
+	switch result.(type) {
+	case *object.Break:
+		return NULL, true
+	case *object.Error, *object.ReturnValue:
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// iterate は obj の要素を順に fn に渡す。fn が false を返すと途中で止める
+// 配列は要素、文字列は 1 文字ずつの文字列、ハッシュはキー、Range は整数を渡す
+// obj が繰り返しできない場合は true を返す
+func iterate(obj object.Object, fn func(object.Object) bool) (notIterable bool) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		for _, elem := range obj.Elements {
+			if !fn(elem) {
+				return false
+			}
+		}
+	case *object.String:
+		for _, ch := range obj.Value {
+			if !fn(&object.String{Value: string(ch)}) {
+				return false
+			}
+		}
+	case *object.Hash:
+		for _, key := range obj.Order {
+			if !fn(obj.Pairs[key].Key) {
+				return false
+			}
+		}
+	case *object.Range:
+		for i, n := uint64(0), obj.Len(); i < n; i++ {
+			if !fn(&object.Integer{Value: obj.At(i)}) {
+				return false
+			}
+		}
+	default:
+		return true
+	}
+	return false
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -113,14 +409,16 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func (ev *evaluation) evalPrefixExpression(node ast.Node, operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusPrefixOperatorExpression(right)
+		return ev.evalMinusPrefixOperatorExpression(node, right)
+	case "~":
+		return evalTildePrefixOperatorExpression(node, right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(node, object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -137,85 +435,323 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
-func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
-	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
-}
-
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func (ev *evaluation) evalInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return ev.evalIntegerInfixExpression(node, operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// 片方でも浮動小数点数なら、両方を浮動小数点数にして計算する
+		return evalFloatInfixExpression(node, operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(node, operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newError(node, object.TYPE_ERROR, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(node, object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+func evalFloatInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError(node, object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError(node, object.ZERO_DIVISION_ERROR, "modulo by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(node, object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *object.BigInt, *object.Float:
+		return true
+	default:
+		return false
+	}
+}
+
+// toFloat は Integer, BigInt, Float を float64 に変換する
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+func evalStringInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(node, object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+func (ev *evaluation) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := ev.eval(ie.Condition, env)
+	if isAbrupt(condition) {
 		return condition
 	}
 
+	var result object.Object
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		result = ev.eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		result = ev.eval(ie.Alternative, env)
 	}
-	return NULL
+
+	// 空のブロックや値を持たない文で終わるブロックは NULL にする
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func (ev *evaluation) evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := ev.eval(te.Block, env)
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil && isCatchable(err) {
+		catchEnv := object.NewEncloseEnv(env)
+		if te.CatchParam != nil {
+			catchEnv.Set(te.CatchParam.Value, caughtValue(err))
+		}
+		result = ev.eval(te.Catch, catchEnv)
+	}
+
+	if te.Finally != nil {
+		// finally でのエラーと return, break, continue は try と catch の結果より優先する
+		finally := ev.eval(te.Finally, env)
+		if isAbrupt(finally) {
+			return finally
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// newThrownError は throw された val を持つエラーを返す
+// message と type を持つハッシュはその値をエラーのメッセージと種類にする
+func newThrownError(node ast.Node, val object.Object) *object.Error {
+	err := &object.Error{
+		Kind:    object.ERROR_KIND,
+		Message: val.Inspect(),
+		Pos:     node.Pos(),
+		End:     node.End(),
+		Value:   val,
+	}
+
+	if hash, ok := val.(*object.Hash); ok {
+		if msg, ok := hash.Get(&object.String{Value: "message"}); ok {
+			err.Message = msg.Inspect()
+		}
+		if kind, ok := hash.Get(&object.String{Value: "type"}); ok {
+			err.Kind = kind.Inspect()
+		}
+	}
+
+	return err
+}
+
+// isCatchable は err を catch できるかどうかを返す
+// キャンセルと資源の制限によるエラーはスクリプトから握りつぶせない
+func isCatchable(err *object.Error) bool {
+	return err.Err == nil && err.Kind != object.LIMIT_ERROR && err.Kind != object.CANCELED_ERROR && err.Kind != object.SYNTAX_ERROR
+}
+
+// caughtValue は catch で束縛する値を返す
+// throw された値はそのまま、実行時エラーは message と type を持つハッシュにする
+func caughtValue(err *object.Error) object.Object {
+	if err.Value != nil {
+		return err.Value
+	}
+
+	kind := err.Kind
+	if kind == "" {
+		kind = object.ERROR_KIND
+	}
+
+	hash := object.NewHash()
+	hash.Set(&object.String{Value: "message"}, &object.String{Value: err.Message})
+	hash.Set(&object.String{Value: "type"}, &object.String{Value: kind})
+	return hash
+}
+
+func (ev *evaluation) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	val := ev.eval(node.Value, env)
+	if isAbrupt(val) {
+		return val
+	}
+
+	if node.Operator != "=" {
+		// x += y は x = x + y と同じ
+		current, ok := env.Get(node.Name.Value)
+		if !ok {
+			return newError(node.Name, object.NAME_ERROR, "assignment to undefined variable: %s", node.Name.Value)
+		}
+
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = ev.alloc(node, ev.evalInfixExpression(node, operator, current, val))
+		if isAbrupt(val) {
+			return val
+		}
+	}
+
+	if _, ok := env.Assign(node.Name.Value, val); !ok {
+		return newError(node.Name, object.NAME_ERROR, "assignment to undefined variable: %s", node.Name.Value)
+	}
+
+	return val
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	val, ok := env.Get(node.Value)
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+
+	if builtin, ok := LookupBuiltin(node.Value); ok {
+		return builtin
+	}
+
+	return newError(node, object.NAME_ERROR, "identifier not found: %s", node.Value)
+}
+
+func evalIndexExpression(node ast.Node, left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(node, left, index)
+	default:
+		return newError(node, object.TYPE_ERROR, "index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// evalArrayIndexExpression は負のインデックスを末尾からの位置として扱い、
+// 範囲外のインデックスには NULL を返す
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	elements := array.(*object.Array).Elements
+	integer, ok := index.(*object.Integer)
 	if !ok {
-		return newError("identifier not found: %s", node.Value)
+		// int64 に収まらないインデックスは必ず範囲外
+		return NULL
 	}
+	idx := integer.Value
+	length := int64(len(elements))
 
-	return val
+	if idx < 0 {
+		idx += length
+	}
+	if idx < 0 || idx >= length {
+		return NULL
+	}
+
+	return elements[idx]
+}
+
+func evalHashIndexExpression(node ast.Node, hash, index object.Object) object.Object {
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError(node, object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
+	}
+
+	value, ok := hash.(*object.Hash).Get(key)
+	if !ok {
+		return NULL
+	}
+
+	return value
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (ev *evaluation) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash()
+
+	for _, keyNode := range node.Keys {
+		key := ev.eval(keyNode, env)
+		if isAbrupt(key) {
+			return key
+		}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError(keyNode, object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+		}
+
+		value := ev.eval(node.Pairs[keyNode], env)
+		if isAbrupt(value) {
+			return value
+		}
+
+		hash.Set(hashKey, value)
+	}
+
+	return hash
+}
+
+func (ev *evaluation) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	result := make([]object.Object, 0, len(exps))
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		evaluated := ev.eval(e, env)
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -224,30 +760,185 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	function, ok := fn.(*object.Function)
-	if !ok {
-		return newError("not a function: %s", fn.Type())
+// namedArgument は評価した名前付き引数
+type namedArgument struct {
+	node  *ast.NamedArgument
+	value object.Object
+}
+
+func (ev *evaluation) applyFunction(node ast.Node, fn object.Object, args []object.Object, named []namedArgument) object.Object {
+	if err := ev.checkCanceled(node); err != nil {
+		return err
 	}
 
-	extendedEnv := extendFunctionEnv(function, args)
-	evaluated := Eval(function.Body, extendedEnv)
-	return unwrapReturnValue(evaluated)
+	switch fn := fn.(type) {
+	case *object.Function:
+		if err := ev.enterCall(node); err != nil {
+			return err
+		}
+		defer ev.leaveCall()
+
+		var evaluated object.Object
+		extendedEnv, err := ev.extendFunctionEnv(node, fn, args, named)
+		if err != nil {
+			evaluated = err
+		} else {
+			evaluated = unwrapReturnValue(ev.eval(fn.Body, extendedEnv))
+		}
+		if err, ok := evaluated.(*object.Error); ok {
+			pushStackFrame(err, fn.Name, node, args, named)
+		}
+		if evaluated == nil {
+			// 値を持たない文で終わる関数は NULL を返す
+			return NULL
+		}
+		return evaluated
+	case *object.Builtin:
+		if len(named) > 0 {
+			err := newError(named[0].node, object.ARGUMENT_ERROR, "builtin function %s does not accept named arguments", fn.Name)
+			pushStackFrame(err, fn.Name, node, args, named)
+			return err
+		}
+		var result object.Object
+		if fn == putsBuiltin {
+			result = builtinPuts(ev.stdout(), args)
+		} else {
+			result = fn.Fn(args...)
+		}
+		if result == nil {
+			return NULL
+		}
+		if err, ok := result.(*object.Error); ok {
+			// 組み込み関数は同じエラーを何度も返すことがあるので、複製してから位置と呼び出しを書き込む
+			copied := *err
+			copied.Stack = append([]object.StackFrame(nil), err.Stack...)
+			if !copied.Pos.IsValid() {
+				copied.Pos, copied.End = node.Pos(), node.End()
+			}
+			pushStackFrame(&copied, fn.Name, node, args, named)
+			return &copied
+		}
+		return ev.alloc(node, result)
+	default:
+		return newError(node, object.TYPE_ERROR, "not a function: %s", fn.Type())
+	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+// extendFunctionEnv は仮引数に引数を束縛した環境を作る
+// 位置引数を前から順に束縛した後、名前付き引数を同じ名前の仮引数に束縛する
+// 引数の無い仮引数には既定値を評価して束縛する。既定値は引数を受け取った仮引数と、
+// それより前の仮引数を参照できる。残りの位置引数は ...rest の配列にまとめる
+func (ev *evaluation) extendFunctionEnv(call ast.Node, fn *object.Function, args []object.Object, named []namedArgument) (*object.Environment, *object.Error) {
+	bound := make([]object.Object, len(fn.Parameters))
+	for i := 0; i < len(args) && i < len(bound); i++ {
+		bound[i] = args[i]
+	}
+
+	for _, arg := range named {
+		paramIdx := parameterIndex(fn, arg.node.Name.Value)
+		if paramIdx < 0 {
+			return nil, newError(arg.node, object.ARGUMENT_ERROR, "unexpected named argument: %s", arg.node.Name.Value)
+		}
+		if bound[paramIdx] != nil {
+			return nil, newError(arg.node, object.ARGUMENT_ERROR, "multiple values for argument: %s", arg.node.Name.Value)
+		}
+		bound[paramIdx] = arg.value
+	}
+
+	if err := checkArity(call, fn, len(args)+len(named)); err != nil {
+		return nil, err
+	}
+
 	env := object.NewEncloseEnv(fn.Env)
+	for paramIdx, param := range fn.Parameters {
+		if bound[paramIdx] != nil {
+			env.Set(param.Value, bound[paramIdx])
+		}
+	}
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if bound[paramIdx] != nil {
+			continue
+		}
+		if paramIdx >= len(fn.Defaults) || fn.Defaults[paramIdx] == nil {
+			return nil, newError(call, object.ARGUMENT_ERROR, "missing argument: %s", param.Value)
+		}
+
+		value, err := ev.evalDefault(fn.Defaults[paramIdx], env)
+		if err != nil {
+			return nil, err
+		}
+		env.Set(param.Value, value)
+	}
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		array := ev.alloc(call, &object.Array{Elements: rest})
+		if err, ok := array.(*object.Error); ok {
+			return nil, err
+		}
+		env.Set(fn.Rest.Value, array)
+	}
+
+	return env, nil
+}
+
+// evalDefault は仮引数の既定値を評価する
+// 既定値の中の return はその値を既定値にする。break と continue で抜けられるループは無い
+func (ev *evaluation) evalDefault(node ast.Expression, env *object.Environment) (object.Object, *object.Error) {
+	switch value := ev.eval(node, env).(type) {
+	case *object.Error:
+		return nil, value
+	case *object.ReturnValue:
+		return value.Value, nil
+	case *object.Break, *object.Continue:
+		return nil, newError(node, object.TYPE_ERROR, "%s outside loop in default value", strings.ToLower(string(value.Type())))
+	case nil:
+		return NULL, nil
+	default:
+		return value, nil
+	}
+}
+
+// parameterIndex は名前が name の仮引数の位置を返す。無ければ -1 を返す
+func parameterIndex(fn *object.Function, name string) int {
+	for i, param := range fn.Parameters {
+		if param.Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkArity は引数の数が fn の仮引数に合っているかを確かめる
+func checkArity(call ast.Node, fn *object.Function, got int) *object.Error {
+	required := 0
+	for i := range fn.Parameters {
+		if i >= len(fn.Defaults) || fn.Defaults[i] == nil {
+			required = i + 1
+		}
 	}
 
-	return env
+	switch {
+	case fn.Rest != nil:
+		if got < required {
+			return newError(call, object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d or more", got, required)
+		}
+	case got < required || got > len(fn.Parameters):
+		if required == len(fn.Parameters) {
+			return newError(call, object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d", got, required)
+		}
+		return newError(call, object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d..%d", got, required, len(fn.Parameters))
+	}
+	return nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
-		return returnValue
+		return returnValue.Value
 	}
 	return obj
 }
@@ -263,14 +954,22 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(node ast.Node, kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, a...),
+		Pos:     node.Pos(),
+		End:     node.End(),
+	}
 }
 
-func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+// isAbrupt は obj がエラーか return, break, continue のように、値の代わりに評価を中断させるものかどうかを返す
+// 式の途中で現れた場合は、値として使わずにエラーと同じように外側へ伝える
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	default:
+		return false
 	}
-
-	return false
 }
@@ -1,10 +1,19 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/atrn0/go-monkey/ast"
 	"github.com/atrn0/go-monkey/lexer"
 	"github.com/atrn0/go-monkey/object"
 	"github.com/atrn0/go-monkey/parser"
+	"io/ioutil"
+	"math"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -21,6 +30,23 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"4 / (2 + 2)", 1},
 		{"3 - 5 + 87", 85},
 		{"90 - -9 * 3", 117},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"5 ** 0", 1},
+		{"0xff + 0o17 + 0b101", 275},
+		{"1_000_000 / 1_000", 1000},
+		{"-0x10", -16},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 + 2 << 3", 24},
+		{"1 | 2 ^ 3 & 4", 3},
 	}
 
 	for _, tt := range tests {
@@ -44,6 +70,167 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return false
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-2.25", -2.25},
+		{"1e3", 1000},
+		{"2.5E-2", 0.025},
+		{"1e+2", 100},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"7 / 2.0", 3.5},
+		{"5.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"2 ** -1", 0.5},
+		{"let price = 19.99; let qty = 3; price * qty", 59.97},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		float, ok := evaluated.(*object.Float)
+		if !ok {
+			t.Errorf("object is not Float for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if math.Abs(float.Value-tt.expected) > 1e-9 {
+			t.Errorf("wrong value for %q. expected %v, got %v", tt.input, tt.expected, float.Value)
+		}
+	}
+
+	inspects := []struct {
+		input    string
+		expected string
+	}{
+		{"1.5", "1.5"},
+		{"2.0", "2.0"},
+		{"1.5 * 2", "3.0"},
+		{"1e21", "1e+21"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+	}
+
+	for _, tt := range inspects {
+		if actual := testEval(tt.input).Inspect(); actual != tt.expected {
+			t.Errorf("wrong Inspect for %q. expected %q, got %q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4611686018427387904 * 2", "9223372036854775808"},
+		{"-9223372036854775807 - 1", "-9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"2 ** 64", "18446744073709551616"},
+		{"3 ** 40", "12157665459056928801"},
+		{"1 << 70", "1180591620717411303424"},
+		{"(1 << 70) >> 69", "2"},
+		{"(2 ** 64) - (2 ** 64) + 1", "1"},
+		{"(2 ** 64) / (2 ** 62)", "4"},
+		{"(2 ** 64 + 5) % 10", "1"},
+		{"-(2 ** 64) >> 100", "-1"},
+		{"~(2 ** 64)", "-18446744073709551617"},
+		{"(2 ** 64) | 1", "18446744073709551617"},
+		{"2 ** 64 > 9223372036854775807", "true"},
+		{"2 ** 64 == 2 ** 64", "true"},
+		{"2 ** 64 * 0.5", "9.223372036854776e+18"},
+		{"(2 ** 64) ** -1", "5.421010862427522e-20"},
+		{"[1, 2][2 ** 64]", "null"},
+		{"type(2 ** 64)", "INTEGER"},
+		{"let h = {2 ** 64: 1}; h[2 ** 64]", "1"},
+		{"let h = {2 ** 64: 1}; h[-(2 ** 64)]", "null"},
+		{`len({-(2 ** 64): "neg", 2 ** 64: "pos"})`, "2"},
+		{`{-(2 ** 64): "neg", 2 ** 64: "pos"}[-(2 ** 64)]`, "neg"},
+		{"9223372036854775808", "9223372036854775808"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"0x1_0000_0000_0000_0000 == 2 ** 64", "true"},
+		{"123456789012345678901234567890 % 1000", "890"},
+		// int64 に収まらない range の要素数
+		{"len(range(-9223372036854775807 - 1, 9223372036854775807))", "18446744073709551615"},
+		{"len(range(9223372036854775807, -9223372036854775807 - 1, -1))", "18446744073709551615"},
+		{"len(range(-9223372036854775807 - 1, 9223372036854775807, 2))", "9223372036854775808"},
+		{"let s = []; for (x in range(9223372036854775807, -9223372036854775807 - 1, -9223372036854775807 - 1)) { s = push(s, x) }; s", "[9223372036854775807, -1]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			t.Errorf("unexpected error for %q: %s", tt.input, errObj.Message)
+			continue
+		}
+		if actual := evaluated.Inspect(); actual != tt.expected {
+			t.Errorf("wrong result for %q. expected %s, got %s", tt.input, tt.expected, actual)
+		}
+	}
+
+	// 大きすぎる整数はメモリを使い果たす前にエラーにする
+	for _, input := range []string{"2 ** 100000000", "1 << 100000000", "let x = 2 ** 1000000; x * x"} {
+		evaluated := testEval(input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok || errObj.Kind != object.OVERFLOW_ERROR {
+			t.Errorf("overflow error expected for %q. got %T (%+v)", input, evaluated, evaluated)
+		}
+	}
+
+	strict := []struct {
+		input       string
+		expectedMsg string
+	}{
+		{"9223372036854775807 + 1", "integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", "integer overflow: -9223372036854775807 - 2"},
+		{"4611686018427387904 * 2", "integer overflow: 4611686018427387904 * 2"},
+		{"2 ** 64", "integer overflow: 2 ** 64"},
+		{"1 << 63", "integer overflow: 1 << 63"},
+		{"-(-9223372036854775807 - 1)", "integer overflow: -(-9223372036854775808)"},
+		{"let x = 9223372036854775807; x += 1", "integer overflow: 9223372036854775807 + 1"},
+		{"9223372036854775808", "integer overflow: 9223372036854775808"},
+		{"-9223372036854775809", "integer overflow: -9223372036854775809"},
+	}
+
+	for _, tt := range strict {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalWithConfig(context.Background(), program, object.NewEnvironment(), Config{Overflow: OverflowError})
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != object.OVERFLOW_ERROR {
+			t.Errorf("wrong kind for %q. got %q", tt.input, errObj.Kind)
+		}
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong message for %q. expected %q, got %q", tt.input, tt.expectedMsg, errObj.Message)
+		}
+	}
+
+	// 符号を付けると int64 に収まるリテラルは厳密モードでもエラーにならない
+	strictValues := []struct {
+		input    string
+		expected int64
+	}{
+		{"-9223372036854775808", math.MinInt64},
+		{"-9223372036854775808 + 1", math.MinInt64 + 1},
+		{"-0x8000_0000_0000_0000", math.MinInt64},
+		{"let x = -9223372036854775808; x", math.MinInt64},
+	}
+
+	for _, tt := range strictValues {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalWithConfig(context.Background(), program, object.NewEnvironment(), Config{Overflow: OverflowError})
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -59,6 +246,17 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 == 2", false},
 		{"1 != 1", false},
 		{"1 != 2", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"5 & 1 == 1", true},
+		{"1 == 1.0", true},
+		{"1.5 < 2", true},
+		{"2.5 >= 2.5", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
 		{"true == true", true},
 		{"false == false", true},
 		{"true == false", false},
@@ -66,6 +264,13 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"false != false", false},
 		{"true != false", true},
 		{"(1 < 2) == true", true},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"abc" < "abd"`, true},
+		{`"b" > "abc"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"1" == 1`, false},
 	}
 
 	for _, tt := range tests {
@@ -119,6 +324,9 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (0 != 0) { 10 }", nil},
 		{"if (0 == 0) { 10 } else { 20 }", 10},
 		{"if (0 != 0) { 10 } else { 20 }", 20},
+		{"if (true) { }", nil},
+		{"if (true) { let x = 1 }", nil},
+		{"if (false) { 10 } else { }", nil},
 	}
 
 	for _, tt := range tests {
@@ -150,6 +358,9 @@ func TestReturnStatement(t *testing.T) {
 		{"return 10; 9;", 10},
 		{"return 2 * 5; 0;", 10},
 		{"9; return 2 * 5; 0;", 10},
+		// 関数の戻り値は ReturnValue に包まれたままにならない
+		{"let f = fn() { return 5 }; f() + 1", 6},
+		{"let f = fn(x) { if (x) { return 1 } 2 }; f(true) * 10", 10},
 		{`
 if (10 > 1) {
 	if (10 > 1) {
@@ -175,6 +386,7 @@ func TestLetStatements(t *testing.T) {
 		{"let a = 5 * 5; a;", 25},
 		{"let a = 5; let b = a; b;", 5},
 		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+		{"let größe = 5; let 値 = größe * 2; 値", 10},
 	}
 
 	for _, tt := range tests {
@@ -196,6 +408,7 @@ func TestErrorHandling(t *testing.T) {
 		{"true + false", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"5; true + true; 5;", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { true + false; 5 }", "unknown operator: BOOLEAN + BOOLEAN"},
 		{`
 if (10 > 1) {
 	if (10 > 1) {
@@ -205,6 +418,26 @@ if (10 > 1) {
 }
 `, "unknown operator: BOOLEAN + BOOLEAN"},
 		{"foobar", "identifier not found: foobar"},
+		{"if (true) { 1 + true; 5 }", "type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn() { foo; 1 }; f()", "identifier not found: foo"},
+		{"x = 1", "assignment to undefined variable: x"},
+		{"x += 1", "assignment to undefined variable: x"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`"a" % "b"`, "unknown operator: STRING % STRING"},
+		{"~true", "unknown operator: ~BOOLEAN"},
+		{"true & false", "unknown operator: BOOLEAN & BOOLEAN"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1 >> -2", "negative shift count: -2"},
+		{`"Hello" + 1`, "type mismatch: STRING + INTEGER"},
+		{`[1, 2]["a"]`, "index operator not supported: ARRAY[STRING]"},
+		{`1[0]`, "index operator not supported: INTEGER[INTEGER]"},
+		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{`{fn(x) { x }: 1}`, "unusable as hash key: FUNCTION"},
+		{`{[1]: 1}`, "unusable as hash key: ARRAY"},
 	}
 
 	for _, tt := range tests {
@@ -221,6 +454,68 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"5 + true;", "1:1"},
+		{"let x = 1;\n-true", "2:1"},
+		{"let f = fn(x) {\n  x + foo\n};\nf(1)", "2:7"},
+		{"5(1)", "1:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected. got %T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong error position. got '%s', expected '%s'.", errObj.Pos, tt.expectedPos)
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 1; a = 2; a", 2},
+		{"let a = 1; a = a + 1", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let a = 10; a += 5; a", 15},
+		{"let a = 10; a -= 5; a", 5},
+		{"let a = 10; a *= 5; a", 50},
+		{"let a = 10; a /= 5; a", 2},
+		{"let a = 1; let f = fn() { a = 5 }; f(); a", 5},
+		{"let a = 1; let f = fn(a) { a = 5 }; f(0); a", 1},
+		{"let a = 1; let f = fn() { let a = 2; a = 5 }; f(); a", 1},
+		{`
+let newCounter = fn() {
+	let count = 0;
+	fn() { count += 1 }
+};
+let counter = newCounter();
+counter();
+counter();
+counter()
+`, 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval(`let s = "a"; s += "b"; s`)
+	if str, ok := evaluated.(*object.String); !ok || str.Value != "ab" {
+		t.Errorf("expected \"ab\". got %T (%+v)", evaluated, evaluated)
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; }"
 	evaluated := testEval(input)
@@ -255,6 +550,7 @@ func TestFunctionApplication(t *testing.T) {
 		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
 		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
 		{"fn(x) { x; }(5)", 5},
+		{"let one = fn() { return 1; }; one() + one();", 2},
 	}
 
 	for _, tt := range tests {
@@ -262,6 +558,152 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x, y = x * 2) { y }; f(4)", 8},
+		{"let n = 0; let f = fn(x = n += 1) { x }; f(); f(); n", 2},
+		{"let base = 5; let f = fn(x = base) { x }; let base = 7; f()", 7},
+		{"let f = fn(first, ...rest) { rest }; f(1, 2, 3)", "[2, 3]"},
+		{"let f = fn(first, ...rest) { rest }; f(1)", "[]"},
+		{"let f = fn(...args) { len(args) }; f()", 0},
+		{"let f = fn(x, y = 2, ...more) { [x, y, more] }; f(1)", "[1, 2, []]"},
+		{"let f = fn(x, y = 2, ...more) { [x, y, more] }; f(1, 3, 5, 7)", "[1, 3, [5, 7]]"},
+		{"let sum = fn(...xs) { let s = 0; for (x in xs) { s += x }; s }; sum(1, 2, 3, 4)", 10},
+		{"fn(x, y = 1, ...z) { x }", "fn(x, y = 1, ...z) {\nx\n}"},
+		{"fn(x = if (true) { return 5 }) { x + 1 }()", 6},
+		{"fn(x = if (true) { }) { x }()", "null"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated.Inspect() != expected {
+				t.Errorf("wrong result for %q. expected %q, got %q", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestDefaultWithLoopControl(t *testing.T) {
+	// パーサは既定値の中の break を拒否するので、ループの中の break を既定値に移した AST を作る
+	loop := parser.New(lexer.New("while (true) { break }")).ParseProgram()
+	breakStmt := loop.Statements[0].(*ast.WhileStatement).Body.Statements[0]
+
+	program := parser.New(lexer.New("fn(x = if (true) { 0 }) { x }()")).ParseProgram()
+	call := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	ifExp := call.Function.(*ast.FunctionLiteral).Defaults[0].(*ast.IfExpression)
+	ifExp.Consequence.Statements = []ast.Statement{breakStmt}
+
+	evaluated := Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "break outside loop in default value" {
+		t.Errorf("wrong message. got %q", errObj.Message)
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(x, y) { [x, y] }; f(y: 2, x: 1)", "[1, 2]"},
+		{"let f = fn(x, y) { [x, y] }; f(1, y: 2)", "[1, 2]"},
+		{"let f = fn(q, limit = 10, offset = 0) { [q, limit, offset] }; f(\"a\", offset: 5)", "[a, 10, 5]"},
+		{"let f = fn(q, limit = 10, offset = 0) { [q, limit, offset] }; f(q: \"a\")", "[a, 10, 0]"},
+		{"let f = fn(x = y + 1, y = 1) { [x, y] }; f(y: 5)", "[6, 5]"},
+		{"let f = fn(x, ...rest) { [x, rest] }; f(1, 2, 3)", "[1, [2, 3]]"},
+		{"let f = fn(a, b = 2, ...rest) { [a, b, rest] }; f(b: 3, a: 1)", "[1, 3, []]"},
+		{"let n = 0; let f = fn(a, b) { a }; f(b: n += 1, a: n += 10); n", "11"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected %q, got %q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"let f = fn(x) { x }; f(y: 1)", "unexpected named argument: y", "1:24"},
+		{"let f = fn(x, y) { x }; f(1, x: 2)", "multiple values for argument: x", "1:30"},
+		{"let f = fn(x, ...rest) { x }; f(rest: 1)", "unexpected named argument: rest", "1:33"},
+		{"let f = fn(x, y, z = 1) { x }; f(1, z: 2)", "missing argument: y", "1:32"},
+		{"let f = fn(x, y) { x }; f(x: 1)", "wrong number of arguments. got=1, want=2", "1:25"},
+		{"len(x: 1)", "builtin function len does not accept named arguments", "1:5"},
+	}
+
+	for _, tt := range errorTests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != object.ARGUMENT_ERROR {
+			t.Errorf("wrong kind for %q. got %q", tt.input, errObj.Kind)
+		}
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong message for %q. expected %q, got %q", tt.input, tt.expectedMsg, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong position for %q. expected %s, got %s", tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestArityErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"let f = fn(x, y) { x + y };\nf(1)", "wrong number of arguments. got=1, want=2", "2:1"},
+		{"let f = fn(x) { x }; f(1, 2)", "wrong number of arguments. got=2, want=1", "1:22"},
+		{"fn() { 1 }(1)", "wrong number of arguments. got=1, want=0", "1:1"},
+		{"let f = fn(x, y = 1) { x }; f()", "wrong number of arguments. got=0, want=1..2", "1:29"},
+		{"let f = fn(x, y = 1) { x }; f(1, 2, 3)", "wrong number of arguments. got=3, want=1..2", "1:29"},
+		{"let f = fn(x, ...rest) { x }; f()", "wrong number of arguments. got=0, want=1 or more", "1:31"},
+		{"let f = fn(x = 1 + true) { x }; f()", "type mismatch: INTEGER + BOOLEAN", "1:16"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong message for %q. expected %q, got %q", tt.input, tt.expectedMsg, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong position for %q. expected %s, got %s", tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+
+	evaluated := testEval("let add = fn(x, y) { x + y }; add(1)")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.ARGUMENT_ERROR || len(errObj.Stack) != 1 || errObj.Stack[0].Function != "add" {
+		t.Errorf("ArgumentError with the add frame expected. got %T (%+v)", evaluated, evaluated)
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 let newAddr = fn(x) {
@@ -274,6 +716,896 @@ addTwo(3)
 	testIntegerObject(t, testEval(input), 5)
 }
 
+func TestStringLiteral(t *testing.T) {
+	input := `"Hello World!"`
+
+	evaluated := testEval(input)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got %T (%+v)", evaluated, evaluated)
+	}
+
+	if str.Value != "Hello World!" {
+		t.Errorf("String has wrong value. got %q", str.Value)
+	}
+}
+
+func TestStringConcatenation(t *testing.T) {
+	input := `"Hello" + " " + "World!\n"`
+
+	evaluated := testEval(input)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got %T (%+v)", evaluated, evaluated)
+	}
+
+	if str.Value != "Hello World!\n" {
+		t.Errorf("String has wrong value. got %q", str.Value)
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got %T (%+v)", evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got %d", len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
+		{"[][0]", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+{
+	"one": 10 - 9,
+	two: 1 + 1,
+	"thr" + "ee": 6 / 2,
+	4: 4,
+	true: 5,
+	false: 6
+}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got %T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		TRUE.HashKey():                             5,
+		FALSE.HashKey():                            6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got %d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+
+	if result.Inspect() != `{one: 1, two: 2, three: 3, 4: 4, true: 5, false: 6}` {
+		t.Errorf("Hash has wrong order. got %s", result.Inspect())
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`{"a": 1, "a": 2}["a"]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len({"a": 1})`, 1},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, nil},
+		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([1])`, []int{}},
+		{`rest([])`, nil},
+		{`push([], 1)`, []int{1}},
+		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`let a = [1]; push(a, 2); a`, []int{1}},
+		{`type("a")`, "STRING"},
+		{`type(len)`, "BUILTIN"},
+		{`let len = fn(x) { 42 }; len("a")`, 42},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error message. expected %q, got %q", expected, obj.Message)
+				}
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("wrong string. expected %q, got %q", expected, obj.Value)
+				}
+			default:
+				t.Errorf("object is not Error or String. got %T (%+v)", evaluated, evaluated)
+			}
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("obj not Array. got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want %d, got %d", len(expected), len(array.Elements))
+				continue
+			}
+
+			for i, expectedElem := range expected {
+				testIntegerObject(t, array.Elements[i], int64(expectedElem))
+			}
+		}
+	}
+}
+
+func TestBuiltinPuts(t *testing.T) {
+	var out bytes.Buffer
+	program := parser.New(lexer.New(`let p = puts; puts("hello", 1); p([true])`)).ParseProgram()
+	evaluated := EvalWithConfig(context.Background(), program, object.NewEnvironment(), Config{Stdout: &out})
+
+	testNullObject(t, evaluated)
+	if out.String() != "hello\n1\n[true]\n" {
+		t.Errorf("puts wrote wrong output. got %q", out.String())
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	RegisterBuiltin("double", func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return &object.Error{Message: "want 1 argument"}
+		}
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	defer delete(builtins, "double")
+
+	testIntegerObject(t, testEval("double(21)"), 42)
+
+	evaluated := testEval("\ndouble()")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Pos.String() != "2:1" {
+		t.Errorf("wrong error position. got %s", errObj.Pos)
+	}
+
+	// 組み込み関数が返したエラーそのものは書き換えない
+	sentinel := &object.Error{Kind: object.VALUE_ERROR, Message: "always fails"}
+	RegisterBuiltin("fail", func(args ...object.Object) object.Object { return sentinel })
+	defer delete(builtins, "fail")
+
+	for _, input := range []string{"fail()", "\n  fail(1)"} {
+		evaluated := testEval(input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok || errObj == sentinel || len(errObj.Stack) != 1 {
+			t.Errorf("copied error expected for %q. got %T (%+v)", input, evaluated, evaluated)
+		}
+	}
+	if sentinel.Pos.IsValid() || len(sentinel.Stack) != 0 {
+		t.Errorf("builtin error was modified: %+v", sentinel)
+	}
+}
+
+func TestConcurrentEvalAndRegisterBuiltin(t *testing.T) {
+	// 評価ごとに puts の出力先が分かれ、評価中に組み込み関数を登録しても競合しない
+	defer delete(builtins, "answer")
+
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, 4)
+	for i := range outs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			program := parser.New(lexer.New(fmt.Sprintf("for (x in range(100)) { len([x]) }; puts(%d)", i))).ParseProgram()
+			EvalWithConfig(context.Background(), program, object.NewEnvironment(), Config{Stdout: &outs[i]})
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		RegisterBuiltin("answer", func(args ...object.Object) object.Object { return &object.Integer{Value: 42} })
+	}
+	wg.Wait()
+
+	for i := range outs {
+		if expected := fmt.Sprintf("%d\n", i); outs[i].String() != expected {
+			t.Errorf("wrong output of evaluation %d. expected %q, got %q", i, expected, outs[i].String())
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	input := `
+let fib = fn(n) {
+	if (n < 2) { return n; }
+	fib(n - 1) + fib(n - 2)
+};
+fib(40)
+`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		ctx         context.Context
+		expectedErr error
+	}{
+		{canceled, context.Canceled},
+		{timeout, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+		}
+
+		if !IsCanceled(errObj) {
+			t.Errorf("IsCanceled(%q) is false", errObj.Message)
+		}
+
+		if errObj.Err != tt.expectedErr {
+			t.Errorf("wrong error. expected %v, got %v", tt.expectedErr, errObj.Err)
+		}
+	}
+
+	if IsCanceled(testEval("1 + true")) {
+		t.Errorf("IsCanceled is true for an ordinary error")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input       string
+		config      Config
+		expectedMsg string
+		expectedPos string
+	}{
+		{
+			"let f = fn(x) { f(x) };\nf(1)",
+			Config{MaxCallDepth: 100},
+			"maximum call depth exceeded (100)",
+			"1:17",
+		},
+		{
+			"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000)",
+			Config{MaxSteps: 500},
+			"maximum evaluation steps exceeded (500)",
+			"1:48",
+		},
+		{
+			"let f = fn(s, n) { if (n == 0) { s } else { f(s + s, n - 1) } }; f(\"ab\", 20)",
+			Config{MaxAllocations: 1000},
+			"maximum allocations exceeded (1000)",
+			"1:47",
+		},
+		{
+			"let f = fn(a, n) { if (n == 0) { a } else { f(push(a, n), n - 1) } }; f([], 100)",
+			Config{MaxAllocations: 1000},
+			"maximum allocations exceeded (1000)",
+			"1:47",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalWithConfig(context.Background(), program, object.NewEnvironment(), tt.config)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected. got %T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong error message. got %q, expected %q", errObj.Message, tt.expectedMsg)
+		}
+
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong error position. got %s, expected %s", errObj.Pos, tt.expectedPos)
+		}
+	}
+
+	// 制限内であれば結果は変わらない
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(50)"
+	program := parser.New(lexer.New(input)).ParseProgram()
+	config := Config{MaxCallDepth: 51, MaxSteps: 10000, MaxAllocations: 10}
+	testIntegerObject(t, EvalWithConfig(context.Background(), program, object.NewEnvironment(), config), 50)
+}
+
+func TestStackTrace(t *testing.T) {
+	input := `let add = fn(x, y) {
+	x + y
+};
+let apply = fn(f, a) { f(a, true) };
+apply(add, "a long string argument");
+`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+
+	expected := []string{
+		"add(a long string arg..., true) (4:24)",
+		"apply(fn(x, y) { (x + y) }, a long string arg...) (5:1)",
+	}
+
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack depth. want %d, got %d: %v", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+
+	for i, frame := range errObj.Stack {
+		if frame.String() != expected[i] {
+			t.Errorf("Stack[%d] wrong. want %q, got %q", i, expected[i], frame.String())
+		}
+	}
+
+	evaluated = testEval("let f = fn() { len(1) }; fn() { f() }()")
+	errObj, ok = evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+
+	var frames []string
+	for _, frame := range errObj.Stack {
+		frames = append(frames, frame.String())
+	}
+	if strings.Join(frames, "; ") != "len(1) (1:16); f() (1:33); <anonymous>() (1:26)" {
+		t.Errorf("wrong stack. got %q", frames)
+	}
+
+	// 名前付き引数も name: value の形で呼び出しに残る
+	for input, expected := range map[string]string{
+		"let f = fn(a, b, c) { a + b + c }; f(1, c: true, b: 2)": "f(1, c: true, b: 2) (1:36)",
+		"len(x: 1)": "len(x: 1) (1:1)",
+	} {
+		evaluated = testEval(input)
+		errObj, ok = evaluated.(*object.Error)
+		if !ok || len(errObj.Stack) == 0 {
+			t.Errorf("error with stack expected for %q. got %T (%+v)", input, evaluated, evaluated)
+			continue
+		}
+		if frame := errObj.Stack[0].String(); frame != expected {
+			t.Errorf("wrong frame for %q. want %q, got %q", input, expected, frame)
+		}
+	}
+
+	// 深い再帰では真ん中の呼び出しを捨て、大きな引数は先頭だけを要約する
+	evaluated = testEval(`let x = "x"; for (i in range(16)) { x = x + x };
+let s = "ab  cd" + x;
+let f = fn(n, s, a) { if (n == 0) { throw "deep" } f(n - 1, s, a) };
+f(1000, s, [s, {s: s}])`)
+	errObj, ok = evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+	if len(errObj.Stack) != maxRecordedFrames || errObj.DroppedFrames != 1001-maxRecordedFrames {
+		t.Fatalf("stack not capped. got %d frames, %d dropped", len(errObj.Stack), errObj.DroppedFrames)
+	}
+	if frame := errObj.Stack[0].String(); frame != "f(0, ab cdxxxxxxxxxxxx..., [ab cdxxxxxxxxxxx...) (3:52)" {
+		t.Errorf("wrong innermost frame. got %q", frame)
+	}
+	if frame := errObj.Stack[len(errObj.Stack)-1].String(); frame != "f(1000, ab cdxxxxxxxxxxxx..., [ab cdxxxxxxxxxxx...) (4:1)" {
+		t.Errorf("wrong outermost frame. got %q", frame)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 1; 2 } catch (e) { e + 10 }", 11},
+		{`try { 1 + true } catch (e) { e["message"] }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`try { 1 + true } catch (e) { e["type"] }`, "TypeError"},
+		{`try { foo } catch (e) { e["type"] }`, "NameError"},
+		{`try { len() } catch (e) { e["type"] }`, "ArgumentError"},
+		{`try { throw "oops" } catch (e) { e }`, "oops"},
+		{`try { throw {"message": "bad", "type": "ValueError"} } catch (e) { e["type"] }`, "ValueError"},
+		{`try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e }`, 2},
+		{"let f = fn() { throw 5 }; try { f() } catch (e) { e * 2 }", 10},
+		{"let e = 1; try { throw 2 } catch (e) { e }; e", 1},
+		{"let r = try { 1 } finally { 2 }; r", 1},
+		{"try { throw 1 } catch (e) { 2 } finally { 3 }", 2},
+		{"let f = fn() { try { return 1 } finally { 2 } }; f()", 1},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", 2},
+		{"let f = fn() { try { throw 1 } finally { return 2 } }; f()", 2},
+		{"try { } catch (e) { 1 }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("wrong string for %q. expected %q, got %q", tt.input, expected, str.Value)
+			}
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestMissingNodes(t *testing.T) {
+	// パーサは欠けたノードを含む文を捨てるが、手で組み立てた AST でも panic せず捕まえられないエラーにする
+	program := parser.New(lexer.New("try { for (x in y) { 1 } } catch (e) { 2 }")).ParseProgram()
+	try := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.TryExpression)
+	try.Block.Statements[0].(*ast.ForStatement).Iterable = nil
+
+	evaluated := Eval(program, object.NewEnvironment())
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Kind != object.SYNTAX_ERROR || errObj.Message != "missing expression" {
+		t.Errorf("wrong error. got %s: %s", errObj.Kind, errObj.Message)
+	}
+	if errObj.Pos.String() != "1:7" {
+		t.Errorf("wrong position. expected 1:7, got %s", errObj.Pos)
+	}
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKind string
+		expectedMsg  string
+		expectedPos  string
+	}{
+		{"let x = 1;\nthrow \"boom\";", object.ERROR_KIND, "boom", "2:1"},
+		{`throw {"message": "bad value", "type": "ValueError"}`, "ValueError", "bad value", "1:1"},
+		{"try { throw 1 } finally { 2 }", object.ERROR_KIND, "1", "1:7"},
+		{"try { 1 } finally { 1 + true }", object.TYPE_ERROR, "type mismatch: INTEGER + BOOLEAN", "1:21"},
+		{"try { 1 } catch (e) { throw e }; 2; throw 3", object.ERROR_KIND, "3", "1:37"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong kind. expected %q, got %q", tt.expectedKind, errObj.Kind)
+		}
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong message. expected %q, got %q", tt.expectedMsg, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong position. expected %s, got %s", tt.expectedPos, errObj.Pos)
+		}
+	}
+
+	// 資源の制限によるエラーは catch できない
+	input := "let f = fn() { f() }; try { f() } catch (e) { 1 }"
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := EvalWithConfig(context.Background(), program, object.NewEnvironment(), Config{MaxCallDepth: 10})
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.LIMIT_ERROR {
+		t.Errorf("limit error expected. got %T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"0 && 2", 2},
+		{"false && 2", false},
+		{"1 || 2", 1},
+		{"false || 2", 2},
+		{`"" || "default"`, ""},
+		{"let x = 0; false && (x = 1); x", 0},
+		{"let x = 0; true || (x = 1); x", 0},
+		{"let x = 0; true && (x = 1); x", 1},
+		{"false && undefinedVariable", false},
+		{"1 < 2 && 2 < 3", true},
+		{"let n = 0; while (n < 10 && n != 4) { n += 1 }; n", 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("wrong string for %q. expected %q, got %q", tt.input, expected, str.Value)
+			}
+		}
+	}
+
+	evaluated := testEval("true && undefinedVariable")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.NAME_ERROR {
+		t.Errorf("name error expected. got %T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 5) { i += 1 }; i", 5},
+		{"let i = 0; while (true) { i += 1; if (i == 3) { break; } }; i", 3},
+		{"let s = 0; let i = 0; while (i < 5) { i += 1; if (i == 2) { continue; } s += i }; s", 13},
+		{"while (false) { 1 }", nil},
+		{"let s = 0; for (x in [1, 2, 3]) { s += x }; s", 6},
+		{"let s = 0; for (x in range(4)) { s += x }; s", 6},
+		{"let s = 0; for (x in range(2, 10, 3)) { s += x }; s", 15},
+		{"let s = 0; for (x in range(5, 0, -2)) { s += x }; s", 9},
+		{`let s = ""; for (c in "abc") { s = c + s }; s`, "cba"},
+		{`let s = ""; for (k in {"a": 1, "b": 2}) { s += k }; s`, "ab"},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } s += x }; s", 3},
+		{"let s = 0; for (x in [1, 2]) { for (y in [10, 20]) { if (y == 20) { continue; } s += x * y } }; s", 30},
+		{"let f = fn() { for (x in range(10)) { if (x == 4) { return x } } }; f()", 4},
+		{"let f = fn() { while (true) { return 7 } }; f()", 7},
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }) }; fs[0]() + fs[1]() * 10", 21},
+		{"let x = 100; for (x in [1]) { }; x", 100},
+		{"let f = fn() { for (x in []) { } }; f()", nil},
+		{"for (x in [1]) { try { break; } finally { } }; 1", 1},
+		// 式の途中の break, continue, return は値にならずにループや関数まで伝わる
+		{"let n = 0; while (true) { n += 1; let x = [if (n > 3) { break }]; }; n", 4},
+		{"let n = 0; while (true) { n += 1; let h = {1: if (n > 2) { break } else { 0 }} }; n", 3},
+		{"let n = 0; while (true) { n += 1; len(if (n > 1) { break } else { [] }) }; n", 2},
+		{"let s = 0; for (x in range(5)) { s += if (x % 2 == 0) { continue } else { x } }; s", 4},
+		{"let f = fn() { let x = 1 + if (true) { return 5 }; 0 }; f()", 5},
+		{"len(range(1, 10, 2))", 5},
+		{"len(range(10, 1))", 0},
+		{"len(range(0, 10, 9223372036854775807))", 1},
+		{"let n = 0; for (i in range(0, 10, 9223372036854775807)) { n += 1 }; n", 1},
+		{"let s = 0; for (i in range(9223372036854775806, 9223372036854775807)) { s = i }; s", 9223372036854775806},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("wrong string for %q. expected %q, got %q", tt.input, expected, str.Value)
+			}
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedKind string
+		expectedMsg  string
+	}{
+		{"for (x in 1) { }", object.TYPE_ERROR, "not iterable: INTEGER"},
+		{"while (1 + true) { }", object.TYPE_ERROR, "type mismatch: INTEGER + BOOLEAN"},
+		{"for (x in [1]) { x + true }", object.TYPE_ERROR, "type mismatch: INTEGER + BOOLEAN"},
+		{"range(1, 2, 0)", object.ARGUMENT_ERROR, "range step must not be zero"},
+		{`range("a")`, object.TYPE_ERROR, "argument to `range` must be INTEGER, got STRING"},
+		{"range(2 ** 64)", object.OVERFLOW_ERROR, "argument to `range` out of range: integer does not fit in 64 bits"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong kind for %q. expected %q, got %q", tt.input, tt.expectedKind, errObj.Kind)
+		}
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong message for %q. expected %q, got %q", tt.input, tt.expectedMsg, errObj.Message)
+		}
+	}
+
+	// 終わらないループもキャンセルで止められる
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	program := parser.New(lexer.New("while (true) { }")).ParseProgram()
+	if evaluated := EvalContext(ctx, program, object.NewEnvironment()); !IsCanceled(evaluated) {
+		t.Errorf("canceled error expected. got %T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"1 / 0", "division by zero", "1:1"},
+		{"1 % 0", "modulo by zero", "1:1"},
+		{"let x = 0;\n10 / x", "division by zero", "2:1"},
+		{"let x = 1; x /= 0", "division by zero", "1:12"},
+		{"(2 ** 64) / 0", "division by zero", "1:2"},
+		{"(2 ** 64) % 0", "modulo by zero", "1:2"},
+		{"1.5 / 0", "division by zero", "1:1"},
+		{"1 / 0.0", "division by zero", "1:1"},
+		{"1.5 % 0.0", "modulo by zero", "1:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("error object expected for %q. got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != object.ZERO_DIVISION_ERROR {
+			t.Errorf("wrong kind for %q. got %q", tt.input, errObj.Kind)
+		}
+		if errObj.Message != tt.expectedMsg {
+			t.Errorf("wrong message for %q. expected %q, got %q", tt.input, tt.expectedMsg, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong position for %q. expected %s, got %s", tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+
+	testIntegerObject(t, testEval("try { 1 / 0 } catch (e) { 42 }"), 42)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("explode", &object.Builtin{Name: "explode", Fn: func(args ...object.Object) object.Object {
+		var arr []int
+		return &object.Integer{Value: int64(arr[len(args)])}
+	}})
+
+	program := parser.New(lexer.New("let x = 1;\ntry { explode(x) } catch (e) { 0 }")).ParseProgram()
+	evaluated := Eval(program, env)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("error object expected. got %T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Kind != object.INTERNAL_ERROR {
+		t.Errorf("wrong kind. got %q", errObj.Kind)
+	}
+	if !strings.Contains(errObj.Message, "index out of range") {
+		t.Errorf("wrong message. got %q", errObj.Message)
+	}
+	if errObj.Pos.String() != "2:7" {
+		t.Errorf("wrong position. expected 2:7, got %s", errObj.Pos)
+	}
+
+	// panic から回復した後も同じ環境で評価を続けられる
+	program = parser.New(lexer.New("x + 1")).ParseProgram()
+	testIntegerObject(t, Eval(program, env), 2)
+}
+
+// crashInputs はホストを落としうる入力。どれもエラーか値を返して評価が終わらなければならない
+var crashInputs = []string{
+	"1 / 0",
+	"1 % 0",
+	"(-9223372036854775807 - 1) / -1",
+	"(-9223372036854775807 - 1) % -1",
+	"1 << 9223372036854775807",
+	"1 >> 9223372036854775807",
+	"2 ** 9223372036854775807",
+	"(2 ** 64) ** (2 ** 64)",
+	"(2 ** 64) << (2 ** 64)",
+	"(2 ** 64) >> (2 ** 64)",
+	"let f = fn(n) { f(n + 1) }; f(0)",
+	"let f = fn() { 1 + f() }; f()",
+	"let f = fn(g) { g(g) }; f(f)",
+	strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000),
+	strings.Repeat("[", 100000),
+	strings.Repeat("{", 100000),
+	strings.Repeat("-", 100000) + "1",
+	strings.Repeat("fn() {", 50000),
+	strings.Repeat("while (true) {", 50000),
+	strings.Repeat("if (true) { ", 50000) + "1" + strings.Repeat(" }", 50000),
+	"1" + strings.Repeat(" + 1", 300000),
+	strings.Repeat("x = ", 100000) + "1",
+	"[1, 2][9223372036854775807]",
+	"[1, 2][-9223372036854775807 - 1]",
+	`"abc"[1]`,
+	"first([]); last([]); rest([])",
+	"first(1)",
+	"push()",
+	"len(range(-9223372036854775807 - 1, 9223372036854775807))",
+	"range(9223372036854775807, -9223372036854775807 - 1, -1)",
+	"for (x in range(0, 10, 9223372036854775807)) { x }",
+	"fn(x) { x }()",
+	"let f = fn(x, y) { x + y }; f(1)",
+	"let fib=fn(0){#000000{0}0000{000000}}fib()",
+	"{}[fn() {}]",
+	`"\u{110000}"`,
+	"\x00\xff\xfe",
+	"try { throw { } } catch (e) { e }",
+	"throw null",
+	"let x = break;",
+	"let0000=fn(A){if(0){}%0}(0)",
+	"if (true) {} + 1",
+	"[if (false) { 1 }, if (true) { let x = 1 }][1] + 1",
+	"for (x in 1) {}",
+	"1.7976931348623157e308 * 10",
+	"1e400",
+	"-(1.0 / 3) % 0.5",
+}
+
+func TestNoInputCrashesHost(t *testing.T) {
+	for _, input := range crashInputs {
+		program := parser.New(lexer.New(input)).ParseProgram()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		evaluated := EvalWithConfig(ctx, program, object.NewEnvironment(), Config{MaxSteps: 1000000})
+		cancel()
+
+		if evaluated == nil {
+			continue
+		}
+		if errObj, ok := evaluated.(*object.Error); ok && errObj.Kind == object.INTERNAL_ERROR {
+			t.Errorf("internal error for %.40q: %s", input, errObj.Message)
+		}
+	}
+
+	// 再帰の深さに上限が無くてもスタックを使い果たす前にエラーになる
+	evaluated := testEval("let f = fn(n) { f(n + 1) }; f(0)")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Kind != object.LIMIT_ERROR {
+		t.Errorf("limit error expected. got %T (%+v)", evaluated, evaluated)
+	}
+}
+
+func FuzzEval(f *testing.F) {
+	for _, input := range crashInputs {
+		if len(input) < 1000 {
+			f.Add(input)
+		}
+	}
+	f.Add("let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)")
+	f.Add(`let h = {"a": [1, 2.5, true]}; for (k in h) { puts(k, h[k]) }`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		// Eval は公開 API なので、構文エラーのあるプログラムの途中までの AST も評価する
+		program := parser.New(lexer.New(input)).ParseProgram()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		config := Config{MaxSteps: 100000, MaxAllocations: 100000, Stdout: ioutil.Discard}
+		evaluated := EvalWithConfig(ctx, program, object.NewEnvironment(), config)
+
+		if errObj, ok := evaluated.(*object.Error); ok && errObj.Kind == object.INTERNAL_ERROR {
+			t.Errorf("internal error for %q: %s", input, errObj.Message)
+		}
+	})
+}
+
 func testEval(input string) object.Object {
 	env := object.NewEnvironment()
 	l := lexer.New(input)
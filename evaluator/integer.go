@@ -0,0 +1,307 @@
+package evaluator
+
+import (
+	"github.com/atrn0/go-monkey/ast"
+	"github.com/atrn0/go-monkey/object"
+	"math"
+	"math/big"
+)
+
+// OverflowMode は整数の演算が int64 に収まらないときの扱い
+type OverflowMode int
+
+const (
+	// OverflowPromote は math/big による任意精度の整数 (object.BigInt) に昇格する
+	OverflowPromote OverflowMode = iota
+	// OverflowError は OVERFLOW_ERROR のエラーにする
+	OverflowError
+)
+
+// maxIntegerBits は任意精度の整数の大きさの上限
+// 2 ** 1000000000 のような式でホストのメモリを使い果たさないようにする
+const maxIntegerBits = 1 << 20
+
+func isInteger(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *object.BigInt:
+		return true
+	default:
+		return false
+	}
+}
+
+// toBig は Integer か BigInt を *big.Int に変換する
+func toBig(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInt:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
+
+// newBigInteger は結果が大きすぎなければ object.NewInteger で整数を返す
+func newBigInteger(node ast.Node, v *big.Int) object.Object {
+	if v.BitLen() > maxIntegerBits {
+		return newError(node, object.OVERFLOW_ERROR, "integer too large (more than %d bits)", maxIntegerBits)
+	}
+	return object.NewInteger(v)
+}
+
+// evalBigIntegerLiteral は int64 に収まらない整数リテラルを評価する
+func (ev *evaluation) evalBigIntegerLiteral(node *ast.BigIntegerLiteral) object.Object {
+	if ev.config.Overflow == OverflowError {
+		return newError(node, object.OVERFLOW_ERROR, "integer overflow: %s", node.Token.Literal)
+	}
+	// AST の値を書き換えられないように複製する
+	return newBigInteger(node, new(big.Int).Set(node.Value))
+}
+
+// evalNegatedBigIntegerLiteral は -9223372036854775808 のように '-' の付いた大きな整数リテラルを評価する
+// 符号の無いリテラルは int64 に収まらなくても、符号を付けた値が収まれば OverflowError にしない
+func (ev *evaluation) evalNegatedBigIntegerLiteral(node *ast.PrefixExpression, literal *ast.BigIntegerLiteral) object.Object {
+	v := new(big.Int).Neg(literal.Value)
+	if ev.config.Overflow == OverflowError && !v.IsInt64() {
+		return newError(node, object.OVERFLOW_ERROR, "integer overflow: -%s", literal.Token.Literal)
+	}
+	return newBigInteger(node, v)
+}
+
+func (ev *evaluation) evalMinusPrefixOperatorExpression(node ast.Node, right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			if ev.config.Overflow == OverflowError {
+				return newError(node, object.OVERFLOW_ERROR, "integer overflow: -(%d)", right.Value)
+			}
+			return newBigInteger(node, new(big.Int).Neg(toBig(right)))
+		}
+		return &object.Integer{Value: -right.Value}
+	case *object.BigInt:
+		return newBigInteger(node, new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
+		return newError(node, object.TYPE_ERROR, "unknown operator: -%s", right.Type())
+	}
+}
+
+func evalTildePrefixOperatorExpression(node ast.Node, right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInt:
+		return newBigInteger(node, new(big.Int).Not(right.Value))
+	default:
+		return newError(node, object.TYPE_ERROR, "unknown operator: ~%s", right.Type())
+	}
+}
+
+// evalIntegerInfixExpression は int64 で計算し、溢れたときは Config.Overflow に従って
+// 任意精度で計算し直すかエラーにする
+func (ev *evaluation) evalIntegerInfixExpression(node ast.Node, operator string, left, right object.Object) object.Object {
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+	if leftOk && rightOk {
+		result, overflow := evalInt64InfixExpression(node, operator, leftInt.Value, rightInt.Value)
+		if !overflow {
+			return result
+		}
+		if ev.config.Overflow == OverflowError {
+			return newError(node, object.OVERFLOW_ERROR, "integer overflow: %d %s %d", leftInt.Value, operator, rightInt.Value)
+		}
+	}
+
+	return evalBigIntInfixExpression(node, operator, toBig(left), toBig(right))
+}
+
+// evalInt64InfixExpression は int64 同士の演算をする。結果が溢れた場合は overflow に true を返す
+func evalInt64InfixExpression(node ast.Node, operator string, leftVal, rightVal int64) (result object.Object, overflow bool) {
+	switch operator {
+	case "+":
+		sum := leftVal + rightVal
+		if (leftVal^sum)&(rightVal^sum) < 0 {
+			return nil, true
+		}
+		return &object.Integer{Value: sum}, false
+	case "-":
+		diff := leftVal - rightVal
+		if (leftVal^rightVal)&(leftVal^diff) < 0 {
+			return nil, true
+		}
+		return &object.Integer{Value: diff}, false
+	case "*":
+		product, ok := multiplyInt64(leftVal, rightVal)
+		if !ok {
+			return nil, true
+		}
+		return &object.Integer{Value: product}, false
+	case "/":
+		if rightVal == 0 {
+			return newError(node, object.ZERO_DIVISION_ERROR, "division by zero"), false
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return nil, true
+		}
+		return &object.Integer{Value: leftVal / rightVal}, false
+	case "%":
+		if rightVal == 0 {
+			return newError(node, object.ZERO_DIVISION_ERROR, "modulo by zero"), false
+		}
+		return &object.Integer{Value: leftVal % rightVal}, false
+	case "**":
+		if rightVal < 0 {
+			// 負の指数の結果は整数にならないので浮動小数点数で返す
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}, false
+		}
+		power, ok := powInt64(leftVal, rightVal)
+		if !ok {
+			return nil, true
+		}
+		return &object.Integer{Value: power}, false
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}, false
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}, false
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}, false
+	case "<<":
+		if rightVal < 0 {
+			return newError(node, object.VALUE_ERROR, "negative shift count: %d", rightVal), false
+		}
+		shifted := leftVal << uint64(rightVal)
+		if shifted>>uint64(rightVal) != leftVal {
+			return nil, true
+		}
+		return &object.Integer{Value: shifted}, false
+	case ">>":
+		if rightVal < 0 {
+			return newError(node, object.VALUE_ERROR, "negative shift count: %d", rightVal), false
+		}
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}, false
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal), false
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal), false
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal), false
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal), false
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal), false
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal), false
+	default:
+		return newError(node, object.TYPE_ERROR, "unknown operator: %s %s %s", object.INTEGER_OBJ, operator, object.INTEGER_OBJ), false
+	}
+}
+
+// multiplyInt64 は a * b を求める。溢れた場合は false を返す
+func multiplyInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+// powInt64 は二乗を繰り返して base の exp 乗を求める。exp は 0 以上
+// 溢れた場合は false を返す
+func powInt64(base, exp int64) (int64, bool) {
+	result := int64(1)
+	for {
+		if exp&1 == 1 {
+			var ok bool
+			if result, ok = multiplyInt64(result, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp == 0 {
+			return result, true
+		}
+		var ok bool
+		if base, ok = multiplyInt64(base, base); !ok {
+			return 0, false
+		}
+	}
+}
+
+func evalBigIntInfixExpression(node ast.Node, operator string, leftVal, rightVal *big.Int) object.Object {
+	switch operator {
+	case "+":
+		return newBigInteger(node, new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return newBigInteger(node, new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return newBigInteger(node, new(big.Int).Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError(node, object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return newBigInteger(node, new(big.Int).Quo(leftVal, rightVal))
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError(node, object.ZERO_DIVISION_ERROR, "modulo by zero")
+		}
+		return newBigInteger(node, new(big.Int).Rem(leftVal, rightVal))
+	case "**":
+		if rightVal.Sign() < 0 {
+			f, _ := new(big.Float).SetInt(leftVal).Float64()
+			e, _ := new(big.Float).SetInt(rightVal).Float64()
+			return &object.Float{Value: math.Pow(f, e)}
+		}
+		// 0, 1, -1 以外の底では結果のビット数がおよそ BitLen * 指数になる
+		if leftVal.CmpAbs(big.NewInt(1)) > 0 &&
+			(!rightVal.IsInt64() || rightVal.Int64() > maxIntegerBits/int64(leftVal.BitLen()-1)) {
+			return newError(node, object.OVERFLOW_ERROR, "integer too large (more than %d bits)", maxIntegerBits)
+		}
+		return newBigInteger(node, new(big.Int).Exp(leftVal, rightVal, nil))
+	case "&":
+		return newBigInteger(node, new(big.Int).And(leftVal, rightVal))
+	case "|":
+		return newBigInteger(node, new(big.Int).Or(leftVal, rightVal))
+	case "^":
+		return newBigInteger(node, new(big.Int).Xor(leftVal, rightVal))
+	case "<<", ">>":
+		if rightVal.Sign() < 0 {
+			return newError(node, object.VALUE_ERROR, "negative shift count: %s", rightVal)
+		}
+		if operator == ">>" {
+			if !rightVal.IsInt64() || rightVal.Int64() > int64(leftVal.BitLen()) {
+				// すべてのビットが押し出されると符号だけが残る
+				if leftVal.Sign() < 0 {
+					return &object.Integer{Value: -1}
+				}
+				return &object.Integer{Value: 0}
+			}
+			return newBigInteger(node, new(big.Int).Rsh(leftVal, uint(rightVal.Int64())))
+		}
+		if leftVal.Sign() == 0 {
+			return &object.Integer{Value: 0}
+		}
+		if !rightVal.IsInt64() || rightVal.Int64() > maxIntegerBits-int64(leftVal.BitLen()) {
+			return newError(node, object.OVERFLOW_ERROR, "integer too large (more than %d bits)", maxIntegerBits)
+		}
+		return newBigInteger(node, new(big.Int).Lsh(leftVal, uint(rightVal.Int64())))
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError(node, object.TYPE_ERROR, "unknown operator: %s %s %s", object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+}
@@ -0,0 +1,100 @@
+package evaluator
+
+import (
+	"github.com/atrn0/go-monkey/ast"
+	"github.com/atrn0/go-monkey/object"
+	"io"
+)
+
+// Config は評価の設定。ゼロ値は制限なし
+// 信頼できないスクリプトを評価する場合は DefaultConfig から始める
+type Config struct {
+	// MaxCallDepth は関数呼び出しのネストの上限
+	MaxCallDepth int
+	// MaxSteps は評価するノード数の上限
+	MaxSteps int
+	// MaxAllocations は生成するオブジェクトの量の上限
+	// 文字列・配列・ハッシュ・関数を 1 つとして数え、
+	// 文字列はバイト数、配列とハッシュは要素数を加算する
+	MaxAllocations int
+	// Overflow は整数の演算が int64 に収まらないときの扱い
+	Overflow OverflowMode
+	// Stdout は puts の出力先。nil の場合は os.Stdout
+	Stdout io.Writer
+}
+
+// DefaultConfig は信頼できないスクリプトを評価するための制限を返す
+// 一般的なスクリプトには十分で、無限ループや無限再帰、メモリの使い過ぎは数秒で止まる
+// monkey.New とコマンドラインの monkey はこの設定で評価する
+func DefaultConfig() Config {
+	return Config{
+		MaxCallDepth:   10000,
+		MaxSteps:       100000000,
+		MaxAllocations: 100000000,
+	}
+}
+
+// maxNesting は評価中のノードのネストの上限
+// 深い再帰や深くネストした式で Go のスタックを使い果たし、ホストごと落ちるのを防ぐ
+const maxNesting = 200000
+
+// enterNode はノードのネストを 1 段深くする
+func (ev *evaluation) enterNode(node ast.Node) *object.Error {
+	ev.nesting++
+	if ev.nesting > maxNesting {
+		return newError(node, object.LIMIT_ERROR, "maximum nesting depth exceeded (%d)", maxNesting)
+	}
+	return nil
+}
+
+// leaveNode は enterNode で深くしたネストを戻す
+func (ev *evaluation) leaveNode() {
+	ev.nesting--
+}
+
+// enterCall は関数呼び出しのネストを 1 段深くする
+func (ev *evaluation) enterCall(node ast.Node) *object.Error {
+	ev.depth++
+	if ev.config.MaxCallDepth > 0 && ev.depth > ev.config.MaxCallDepth {
+		return newError(node, object.LIMIT_ERROR, "maximum call depth exceeded (%d)", ev.config.MaxCallDepth)
+	}
+	return nil
+}
+
+func (ev *evaluation) leaveCall() {
+	ev.depth--
+}
+
+// step は評価したノードを数える
+func (ev *evaluation) step(node ast.Node) *object.Error {
+	ev.steps++
+	if ev.config.MaxSteps > 0 && ev.steps > ev.config.MaxSteps {
+		return newError(node, object.LIMIT_ERROR, "maximum evaluation steps exceeded (%d)", ev.config.MaxSteps)
+	}
+	return nil
+}
+
+// alloc は obj の生成を数え、上限を超えていればエラーを返す
+func (ev *evaluation) alloc(node ast.Node, obj object.Object) object.Object {
+	if ev.config.MaxAllocations <= 0 {
+		return obj
+	}
+
+	switch obj := obj.(type) {
+	case *object.String:
+		ev.allocations += 1 + len(obj.Value)
+	case *object.Array:
+		ev.allocations += 1 + len(obj.Elements)
+	case *object.Hash:
+		ev.allocations += 1 + len(obj.Pairs)
+	case *object.Function:
+		ev.allocations++
+	default:
+		return obj
+	}
+
+	if ev.allocations > ev.config.MaxAllocations {
+		return newError(node, object.LIMIT_ERROR, "maximum allocations exceeded (%d)", ev.config.MaxAllocations)
+	}
+	return obj
+}
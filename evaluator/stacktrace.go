@@ -0,0 +1,147 @@
+package evaluator
+
+import (
+	"fmt"
+	"github.com/atrn0/go-monkey/ast"
+	"github.com/atrn0/go-monkey/object"
+	"strings"
+	"unicode"
+)
+
+// maxArgSummary はスタックトレースに表示する引数 1 つあたりの最大の長さ
+const maxArgSummary = 20
+
+// maxRecordedFrames はエラーに記録する呼び出しの上限
+// 超えた分は内側と外側の半分ずつを残し、真ん中の呼び出しを捨てる
+const maxRecordedFrames = 100
+
+// pushStackFrame は err に name(args, named...) の呼び出しを追加する
+// 名前付き引数は位置引数の後に name: value の形で並べる
+func pushStackFrame(err *object.Error, name string, call ast.Node, args []object.Object, named []namedArgument) {
+	summaries := make([]string, 0, len(args)+len(named))
+	for _, arg := range args {
+		summaries = append(summaries, summarizeArg(arg))
+	}
+	for _, arg := range named {
+		summaries = append(summaries, arg.node.Name.Value+": "+summarizeArg(arg.value))
+	}
+
+	frame := object.StackFrame{
+		Function: name,
+		Pos:      call.Pos(),
+		Args:     strings.Join(summaries, ", "),
+	}
+
+	if len(err.Stack) >= maxRecordedFrames {
+		middle := maxRecordedFrames / 2
+		copy(err.Stack[middle:], err.Stack[middle+1:])
+		err.Stack[len(err.Stack)-1] = frame
+		err.DroppedFrames++
+		return
+	}
+	err.Stack = append(err.Stack, frame)
+}
+
+// summarizeArg は arg の Inspect() の空白をまとめ、maxArgSummary 文字に切り詰めたものを返す
+// 大きな文字列や配列でも先頭の maxArgSummary 文字を超えた部分は読まない
+func summarizeArg(arg object.Object) string {
+	s := &argSummary{}
+	s.writeObject(arg)
+
+	runes := []rune(s.out.String())
+	if len(runes) > maxArgSummary {
+		runes = append(runes[:maxArgSummary-3], []rune("...")...)
+	}
+	return string(runes)
+}
+
+// argSummary は連続する空白を 1 つにまとめながら、maxArgSummary 文字を超えるまで書き込む
+type argSummary struct {
+	out   strings.Builder
+	runes int
+	space bool // 空白を読んだが、まだ書き込んでいない
+}
+
+func (s *argSummary) full() bool {
+	return s.runes > maxArgSummary
+}
+
+func (s *argSummary) writeString(str string) {
+	for _, ch := range str {
+		if s.full() {
+			return
+		}
+		if unicode.IsSpace(ch) {
+			s.space = true
+			continue
+		}
+		if s.space && s.runes > 0 {
+			s.out.WriteByte(' ')
+			s.runes++
+		}
+		s.space = false
+		s.out.WriteRune(ch)
+		s.runes++
+	}
+}
+
+func (s *argSummary) writeObject(obj object.Object) {
+	if s.full() {
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *object.String:
+		s.writeString(obj.Value)
+	case *object.BigInt:
+		// 大きな整数を 10 進数に変換するのは遅いので桁数だけを示す
+		if obj.Value.BitLen() > 256 {
+			s.writeString(fmt.Sprintf("<%d-bit integer>", obj.Value.BitLen()))
+			return
+		}
+		s.writeString(obj.Inspect())
+	case *object.Array:
+		s.writeString("[")
+		for i, element := range obj.Elements {
+			if s.full() {
+				return
+			}
+			if i > 0 {
+				s.writeString(", ")
+			}
+			s.writeObject(element)
+		}
+		s.writeString("]")
+	case *object.Hash:
+		s.writeString("{")
+		for i, key := range obj.Order {
+			if s.full() {
+				return
+			}
+			if i > 0 {
+				s.writeString(", ")
+			}
+			pair := obj.Pairs[key]
+			s.writeObject(pair.Key)
+			s.writeString(": ")
+			s.writeObject(pair.Value)
+		}
+		s.writeString("}")
+	case *object.Function:
+		s.writeString("fn(")
+		s.writeString(strings.Join(ast.ParameterStrings(obj.Parameters, obj.Defaults, obj.Rest), ", "))
+		s.writeString(") {\n")
+		for _, stmt := range obj.Body.Statements {
+			if s.full() {
+				return
+			}
+			s.writeString(stmt.String())
+		}
+		s.writeString("\n}")
+	case *object.Error:
+		s.writeString("ERROR: ")
+		s.writeString(obj.Message)
+	default:
+		s.writeString(obj.Inspect())
+	}
+}
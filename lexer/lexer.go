@@ -1,34 +1,109 @@
 package lexer
 
-import "github.com/atrn0/go-monkey/token"
+import (
+	"fmt"
+	"github.com/atrn0/go-monkey/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
+// Lexer は UTF-8 のソースコードを 1 文字 (rune) ずつ読んでトークンに分ける
 type Lexer struct {
+	filename     string
 	input        string
-	position     int
-	readPosition int
-	ch           byte
+	position     int // l.ch の先頭のバイトオフセット
+	readPosition int // l.ch の次の文字の先頭のバイトオフセット
+	ch           rune
+
+	// l.ch の行と文字単位の列 (1 始まり)
+	line   int
+	column int
+	// lineStart は現在の行の先頭のバイトオフセット
+	lineStart int
+
+	// KeepComments を true にすると、コメントを読み飛ばさずに COMMENT トークンとして返す
+	// フォーマッタのようにコメントを残したいツールのためのモード
+	KeepComments bool
+
+	errors []Error
+}
+
+// Error は字句解析で見つかった問題。問題のある箇所は ILLEGAL トークンになる
+type Error struct {
+	Pos     token.Position
+	End     token.Position
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile はトークンの位置情報にファイル名を含める Lexer を返す
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.position >= len(l.input) && l.column > 0 {
+		// EOF に達したあとは位置を進めない
+		l.ch = 0
+		return
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+		l.lineStart = l.readPosition
+	}
+	width := 0
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		// 不正な UTF-8 のバイトは幅 1 の utf8.RuneError になる
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
+	l.column++
+}
+
+// pos は l.ch の位置を返す
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename:   l.filename,
+		Offset:     l.position,
+		Line:       l.line,
+		Column:     l.column,
+		ByteColumn: l.position - l.lineStart + 1,
+	}
+}
+
+// Errors は字句解析で見つかった問題を見つかった順に返す
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) addError(tok token.Token, message string) {
+	l.errors = append(l.errors, Error{Pos: tok.Pos, End: tok.End, Message: message})
 }
 
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
+	var errMessage string
+
+	if comment, ok := l.skipWhiteSpaceAndComments(); ok {
+		return comment
+	}
 
-	l.skipWhiteSpace()
+	start := l.pos()
 
 	switch l.ch {
 	case '=':
@@ -41,13 +116,17 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newTokenWithAssign(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newTokenWithAssign(token.MINUS, token.MINUS_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			tok = l.newTwoCharToken(token.POWER)
+		} else {
+			tok = l.newTokenWithAssign(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.newTokenWithAssign(token.SLASH, token.SLASH_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -57,14 +136,56 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.newTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.newTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.newTwoCharToken(token.SHIFT_LEFT)
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.newTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.newTwoCharToken(token.SHIFT_RIGHT)
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.readPosition+1 < len(l.input) && l.input[l.readPosition+1] == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -73,6 +194,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
+	case '"':
+		str, message := l.readString()
+		if message == "" {
+			tok = token.Token{Type: token.STRING, Literal: str}
+		} else {
+			tok = token.Token{Type: token.ILLEGAL, Literal: str}
+			errMessage = message
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -80,34 +213,62 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos, tok.End = start, l.pos()
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type, errMessage = l.readNumber()
+			tok.Pos, tok.End = start, l.pos()
+			if errMessage != "" {
+				tok.Type = token.ILLEGAL
+				l.addError(tok, errMessage)
+			}
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			// 不正な UTF-8 でも元のバイト列をそのまま残す
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:l.readPosition]}
 		}
 	}
 
 	l.readChar()
+	tok.Pos, tok.End = start, l.pos()
+	if errMessage != "" {
+		l.addError(tok, errMessage)
+	}
 	return tok
 }
 
-func newToken(tokenType token.Type, ch byte) token.Token {
+func newToken(tokenType token.Type, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// newTwoCharToken は現在の文字と次の文字からなる 2 文字のトークンを返す
+func (l *Lexer) newTwoCharToken(tokenType token.Type) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
+// newTokenWithAssign は次の文字が '=' なら "+=" のような複合代入のトークンを返す
+func (l *Lexer) newTokenWithAssign(tokenType, assignType token.Type) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: assignType, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(tokenType, l.ch)
+}
+
+// readIdentifier は文字で始まり、文字か数字が続く識別子を読む
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
 }
 
 func (l *Lexer) skipWhiteSpace() {
@@ -116,22 +277,277 @@ func (l *Lexer) skipWhiteSpace() {
 	}
 }
 
-func (l *Lexer) readNumber() string {
+// skipWhiteSpaceAndComments は空白とコメントを読み飛ばす
+// KeepComments が true のときはコメントを、閉じられていないコメントがあったときは
+// ILLEGAL トークンを返す
+func (l *Lexer) skipWhiteSpaceAndComments() (token.Token, bool) {
+	for {
+		l.skipWhiteSpace()
+		if !l.isCommentStart() {
+			return token.Token{}, false
+		}
+
+		start := l.pos()
+		literal, ok := l.readComment()
+		tok := token.Token{Type: token.COMMENT, Literal: literal, Pos: start, End: l.pos()}
+		if !ok {
+			tok.Type = token.ILLEGAL
+			l.addError(tok, "unterminated comment")
+			return tok, true
+		}
+		if l.KeepComments {
+			return tok, true
+		}
+	}
+}
+
+// isCommentStart は "//", "/*" か、ファイル先頭の "#!" の上にいるかどうかを返す
+func (l *Lexer) isCommentStart() bool {
+	switch l.ch {
+	case '/':
+		return l.peekChar() == '/' || l.peekChar() == '*'
+	case '#':
+		return l.position == 0 && l.peekChar() == '!'
+	default:
+		return false
+	}
+}
+
+// readComment はコメントを読み、その文字列を返す
+// "/* */" は入れ子にでき、閉じられていない場合は false を返す
+func (l *Lexer) readComment() (string, bool) {
 	position := l.position
-	for isDigit(l.ch) {
+
+	if l.ch == '/' && l.peekChar() == '*' {
+		depth := 0
+		for {
+			switch {
+			case l.ch == 0:
+				return l.input[position:l.position], false
+			case l.ch == '/' && l.peekChar() == '*':
+				depth++
+				l.readChar()
+			case l.ch == '*' && l.peekChar() == '/':
+				depth--
+				l.readChar()
+				if depth == 0 {
+					l.readChar()
+					return l.input[position:l.position], true
+				}
+			}
+			l.readChar()
+		}
+	}
+
+	// "//" と "#!" は行末まで
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return l.input[position:l.position], true
+}
+
+// readNumber は整数か浮動小数点数のリテラルを読む
+// 整数は 0x, 0o, 0b の接頭辞で 16, 8, 2 進数にでき、数字の間を '_' で区切れる
+// 小数点の後と指数の e の後に数字が無ければ、そこまでを数値として扱う
+// リテラルの形が正しくない場合は、その理由を 3 つ目の値で返す
+func (l *Lexer) readNumber() (string, token.Type, string) {
+	position := l.position
+
+	if l.ch == '0' {
+		if base, name, ok := numberBase(l.peekChar()); ok {
+			l.readChar()
+			l.readChar()
+			// 続く英数字はすべてリテラルの一部として読み、まとめて検査する
+			for isLetter(l.ch) || isDigit(l.ch) {
+				l.readChar()
+			}
+			literal := l.input[position:l.position]
+			return literal, token.INT, checkPrefixedDigits(literal[2:], base, name)
+		}
+	}
+
+	tokenType := token.Type(token.INT)
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponentStart() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+
+	literal := l.input[position:l.position]
+	if message := checkDecimalSeparators(literal); message != "" {
+		return literal, tokenType, message
+	}
+	// 010 を 8 進数と 10 進数のどちらで読むかが曖昧になるので、先頭の 0 は許さない
+	if tokenType == token.INT && len(literal) > 1 && literal[0] == '0' {
+		return literal, tokenType, "leading zeros in decimal integer literal (use 0o for octal)"
+	}
+	return literal, tokenType, ""
+}
+
+// readDigits は数字と区切りの '_' を読む
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.readChar()
+	}
+}
+
+// numberBase は "0x" のような接頭辞の 2 文字目から基数とその名前を返す
+func numberBase(ch rune) (int, string, bool) {
+	switch ch {
+	case 'x', 'X':
+		return 16, "hexadecimal", true
+	case 'o', 'O':
+		return 8, "octal", true
+	case 'b', 'B':
+		return 2, "binary", true
+	default:
+		return 0, "", false
+	}
+}
+
+// checkPrefixedDigits は接頭辞の後の digits が base 進数の数字として正しいかを調べる
+// 接頭辞の直後の '_' は許す (0x_ff)
+func checkPrefixedDigits(digits string, base int, name string) string {
+	if strings.Trim(digits, "_") == "" {
+		return fmt.Sprintf("%s literal has no digits", name)
+	}
+
+	for i, ch := range digits {
+		if ch == '_' {
+			if i+1 == len(digits) || digits[i+1] == '_' {
+				return "'_' must separate successive digits"
+			}
+			continue
+		}
+		if digitValue(ch) >= base {
+			return fmt.Sprintf("invalid digit %q in %s literal", ch, name)
+		}
+	}
+	return ""
 }
 
-func isDigit(ch byte) bool {
+// checkDecimalSeparators は 10 進数のリテラルの '_' が数字と数字の間にあるかを調べる
+func checkDecimalSeparators(literal string) string {
+	for i := 0; i < len(literal); i++ {
+		if literal[i] != '_' {
+			continue
+		}
+		if i == 0 || i+1 == len(literal) || !isDigit(rune(literal[i-1])) || !isDigit(rune(literal[i+1])) {
+			return "'_' must separate successive digits"
+		}
+	}
+	return ""
+}
+
+// digitValue は 36 進数までの数字としての ch の値を返す。数字でなければ 36 を返す
+func digitValue(ch rune) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'z':
+		return int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'Z':
+		return int(ch-'A') + 10
+	default:
+		return 36
+	}
+}
+
+// isExponentStart は現在の 'e' の後に指数の数字が続くかどうかを返す
+func (l *Lexer) isExponentStart() bool {
+	next := l.readPosition
+	if next < len(l.input) && (l.input[next] == '+' || l.input[next] == '-') {
+		next++
+	}
+	return next < len(l.input) && isDigit(rune(l.input[next]))
+}
+
+// readString は '"' から閉じる '"' までを読み、エスケープシーケンスを展開した文字列を返す
+// 閉じられていない場合や不正なエスケープがある場合は元の文字列とその理由を返す
+func (l *Lexer) readString() (string, string) {
+	position := l.position
+	var out strings.Builder
+	ok := true
+
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			if !ok {
+				return l.input[position : l.position+1], "invalid escape sequence in string literal"
+			}
+			return out.String(), ""
+		case 0:
+			return l.input[position:l.position], "unterminated string literal"
+		case '\\':
+			if !l.readEscape(&out) {
+				ok = false
+			}
+		default:
+			// 不正な UTF-8 のバイトも置き換えずにそのまま残す
+			out.WriteString(l.input[l.position:l.readPosition])
+		}
+	}
+}
+
+// readEscape は '\' に続くエスケープシーケンスを読んで out に書き込む
+func (l *Lexer) readEscape(out *strings.Builder) bool {
+	l.readChar()
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '"':
+		out.WriteByte('"')
+	case '\\':
+		out.WriteByte('\\')
+	case 'u':
+		// \u{1F600}
+		if l.peekChar() != '{' {
+			return false
+		}
+		l.readChar()
+		position := l.position + 1
+		for l.peekChar() != '}' {
+			if l.peekChar() == 0 || l.peekChar() == '"' {
+				return false
+			}
+			l.readChar()
+		}
+		code, err := strconv.ParseUint(l.input[position:l.position+1], 16, 32)
+		l.readChar()
+		if err != nil || code > 0x10FFFF {
+			return false
+		}
+		out.WriteRune(rune(code))
+	default:
+		return false
+	}
+	return true
+}
+
+// isDigit は ASCII の数字かどうかを返す。数値リテラルは ASCII の数字だけで書く
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
@@ -15,7 +15,7 @@ func TestNextToken(t *testing.T) {
 		};
 		
 		let result = add(five, ten);
-		!-/*5;
+		!-/ *5;
 		5 < 10 > 5;
 		
 		if (5 < 10) {
@@ -26,6 +26,15 @@ func TestNextToken(t *testing.T) {
 		
 		10 == 10;
 		10 != 9;
+		[1, 2];
+		{"foo": "bar"}
+		try { throw 1; } catch (e) { } finally { }
+		x += 1 -= 2 *= 3 /= 4
+		while for in break continue
+		a && b || c
+		<= >= % ** & | ^ ~ << >> < >
+		1.5 2e10 3.0e-2 4.foo 5e
+		fn(...rest) ..
 		`
 
 	tests := []struct {
@@ -105,6 +114,78 @@ func TestNextToken(t *testing.T) {
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.STRING, "foo"},
+		{token.COLON, ":"},
+		{token.STRING, "bar"},
+		{token.RBRACE, "}"},
+		{token.TRY, "try"},
+		{token.LBRACE, "{"},
+		{token.THROW, "throw"},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.CATCH, "catch"},
+		{token.LPAREN, "("},
+		{token.IDENT, "e"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.FINALLY, "finally"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.LT_EQ, "<="},
+		{token.GT_EQ, ">="},
+		{token.PERCENT, "%"},
+		{token.POWER, "**"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.BIT_XOR, "^"},
+		{token.TILDE, "~"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.LT, "<"},
+		{token.GT, ">"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "2e10"},
+		{token.FLOAT, "3.0e-2"},
+		{token.INT, "4"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "foo"},
+		{token.INT, "5"},
+		{token.IDENT, "e"},
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "rest"},
+		{token.RPAREN, ")"},
+		{token.ILLEGAL, "."},
+		{token.ILLEGAL, "."},
 		{token.EOF, ""},
 	}
 
@@ -123,3 +204,264 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "let x = 10;\n  x == 5"
+
+	tests := []struct {
+		expectedType token.Type
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.LET, token.Position{Offset: 0, Line: 1, Column: 1, ByteColumn: 1}, token.Position{Offset: 3, Line: 1, Column: 4, ByteColumn: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5, ByteColumn: 5}, token.Position{Offset: 5, Line: 1, Column: 6, ByteColumn: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7, ByteColumn: 7}, token.Position{Offset: 7, Line: 1, Column: 8, ByteColumn: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9, ByteColumn: 9}, token.Position{Offset: 10, Line: 1, Column: 11, ByteColumn: 11}},
+		{token.SEMICOLON, token.Position{Offset: 10, Line: 1, Column: 11, ByteColumn: 11}, token.Position{Offset: 11, Line: 1, Column: 12, ByteColumn: 12}},
+		{token.IDENT, token.Position{Offset: 14, Line: 2, Column: 3, ByteColumn: 3}, token.Position{Offset: 15, Line: 2, Column: 4, ByteColumn: 4}},
+		{token.EQ, token.Position{Offset: 16, Line: 2, Column: 5, ByteColumn: 5}, token.Position{Offset: 18, Line: 2, Column: 7, ByteColumn: 7}},
+		{token.INT, token.Position{Offset: 19, Line: 2, Column: 8, ByteColumn: 8}, token.Position{Offset: 20, Line: 2, Column: 9, ByteColumn: 9}},
+		{token.EOF, token.Position{Offset: 20, Line: 2, Column: 9, ByteColumn: 9}, token.Position{Offset: 20, Line: 2, Column: 9, ByteColumn: 9}},
+		{token.EOF, token.Position{Offset: 20, Line: 2, Column: 9, ByteColumn: 9}, token.Position{Offset: 20, Line: 2, Column: 9, ByteColumn: 9}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `#!/usr/bin/env monkey
+let x = 1; // line comment
+/* block /* nested */ still comment */ x
+// last line`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.COMMENT, "#!/usr/bin/env monkey"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// line comment"},
+		{token.COMMENT, "/* block /* nested */ still comment */"},
+		{token.IDENT, "x"},
+		{token.COMMENT, "// last line"},
+		{token.EOF, ""},
+	}
+
+	// コメントを読み飛ばすモード
+	l := New(input)
+	for i, tt := range tests {
+		if tt.expectedType == token.COMMENT {
+			continue
+		}
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	// コメントを残すモード
+	l = New(input)
+	l.KeepComments = true
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	others := []struct {
+		input           string
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{"/* unterminated /* */", token.ILLEGAL, "/* unterminated /* */"},
+		{"/**/ 1", token.INT, "1"},
+		{"/*/ 1 */ 2", token.INT, "2"},
+		{"1 #! not a shebang", token.INT, "1"},
+		{"10 / 2", token.INT, "10"},
+	}
+
+	for i, tt := range others {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("others[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	// コメントの後のトークンの位置
+	l = New("/* a\nb */ x")
+	if tok := l.NextToken(); tok.Pos.String() != "2:6" {
+		t.Errorf("wrong position after comment. got %s", tok.Pos)
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let größe = \"日本\"; größe\n変数_1 ü2 ①"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		expectedPos     token.Position
+	}{
+		{token.LET, "let", token.Position{Offset: 0, Line: 1, Column: 1, ByteColumn: 1}},
+		{token.IDENT, "größe", token.Position{Offset: 4, Line: 1, Column: 5, ByteColumn: 5}},
+		{token.ASSIGN, "=", token.Position{Offset: 12, Line: 1, Column: 11, ByteColumn: 13}},
+		{token.STRING, "日本", token.Position{Offset: 14, Line: 1, Column: 13, ByteColumn: 15}},
+		{token.SEMICOLON, ";", token.Position{Offset: 22, Line: 1, Column: 17, ByteColumn: 23}},
+		{token.IDENT, "größe", token.Position{Offset: 24, Line: 1, Column: 19, ByteColumn: 25}},
+		{token.IDENT, "変数_1", token.Position{Offset: 32, Line: 2, Column: 1, ByteColumn: 1}},
+		{token.IDENT, "ü2", token.Position{Offset: 41, Line: 2, Column: 6, ByteColumn: 10}},
+		{token.ILLEGAL, "①", token.Position{Offset: 45, Line: 2, Column: 9, ByteColumn: 14}},
+		{token.EOF, "", token.Position{Offset: 48, Line: 2, Column: 10, ByteColumn: 17}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+	}
+
+	// 不正な UTF-8 のバイトは 1 バイトずつ ILLEGAL になり、文字列の中ではそのまま残る
+	l = New("\xff\"a\xfeb\"")
+	if tok := l.NextToken(); tok.Type != token.ILLEGAL || tok.Literal != "\xff" {
+		t.Errorf("expected ILLEGAL \\xff. got %s %q", tok.Type, tok.Literal)
+	}
+	if tok := l.NextToken(); tok.Type != token.STRING || tok.Literal != "a\xfeb" {
+		t.Errorf("expected STRING. got %s %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{`"foobar"`, token.STRING, "foobar"},
+		{`"foo bar"`, token.STRING, "foo bar"},
+		{`""`, token.STRING, ""},
+		{`"a\nb\tc"`, token.STRING, "a\nb\tc"},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"\u{48}\u{e9}\u{1F600}"`, token.STRING, "Hé😀"},
+		{`"unterminated`, token.ILLEGAL, `"unterminated`},
+		{`"bad \q escape"`, token.ILLEGAL, `"bad \q escape"`},
+		{`"\u{110000}"`, token.ILLEGAL, `"\u{110000}"`},
+		{`"\u{zz}"`, token.ILLEGAL, `"\u{zz}"`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Fatalf("tests[%d] - expected EOF after string. got=%q", i, tok.Type)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.Type
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"0xff", token.INT, "0xff", ""},
+		{"0XFF", token.INT, "0XFF", ""},
+		{"0o17", token.INT, "0o17", ""},
+		{"0b1010", token.INT, "0b1010", ""},
+		{"1_000_000", token.INT, "1_000_000", ""},
+		{"0x_ff_ff", token.INT, "0x_ff_ff", ""},
+		{"1_0.2_5e1_0", token.FLOAT, "1_0.2_5e1_0", ""},
+		{"0x", token.ILLEGAL, "0x", "hexadecimal literal has no digits"},
+		{"0b_", token.ILLEGAL, "0b_", "binary literal has no digits"},
+		{"0b102", token.ILLEGAL, "0b102", "invalid digit '2' in binary literal"},
+		{"0o8", token.ILLEGAL, "0o8", "invalid digit '8' in octal literal"},
+		{"0xfg", token.ILLEGAL, "0xfg", "invalid digit 'g' in hexadecimal literal"},
+		{"0x1__2", token.ILLEGAL, "0x1__2", "'_' must separate successive digits"},
+		{"1__0", token.ILLEGAL, "1__0", "'_' must separate successive digits"},
+		{"10_", token.ILLEGAL, "10_", "'_' must separate successive digits"},
+		{"1_.5", token.ILLEGAL, "1_.5", "'_' must separate successive digits"},
+		{"1e5_", token.ILLEGAL, "1e5_", "'_' must separate successive digits"},
+		{"010", token.ILLEGAL, "010", "leading zeros in decimal integer literal (use 0o for octal)"},
+		{"0_10", token.ILLEGAL, "0_10", "leading zeros in decimal integer literal (use 0o for octal)"},
+		{"0", token.INT, "0", ""},
+		{"0.5", token.FLOAT, "0.5", ""},
+		{"00.5", token.FLOAT, "00.5", ""},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Fatalf("tests[%d] - expected EOF after number. got %s %q", i, next.Type, next.Literal)
+		}
+
+		errors := l.Errors()
+		if tt.expectedError == "" {
+			if len(errors) != 0 {
+				t.Errorf("tests[%d] - unexpected errors: %v", i, errors)
+			}
+			continue
+		}
+		if len(errors) != 1 || errors[0].Message != tt.expectedError {
+			t.Errorf("tests[%d] - expected error %q, got %v", i, tt.expectedError, errors)
+			continue
+		}
+		if errors[0].Pos != tok.Pos || errors[0].End != tok.End {
+			t.Errorf("tests[%d] - error range wrong. got %s-%s", i, errors[0].Pos, errors[0].End)
+		}
+	}
+
+	// 文字列とコメントの問題も Errors で取得できる
+	l := New(`"bad \q" "open`)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+	errors := l.Errors()
+	if len(errors) != 2 ||
+		errors[0].String() != "1:1: invalid escape sequence in string literal" ||
+		errors[1].String() != "1:10: unterminated string literal" {
+		t.Errorf("wrong errors. got %v", errors)
+	}
+}
@@ -1,17 +1,148 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/atrn0/go-monkey/diagnostics"
+	"github.com/atrn0/go-monkey/monkey"
+	"github.com/atrn0/go-monkey/object"
 	"github.com/atrn0/go-monkey/repl"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 )
 
+const usage = `Usage:
+  monkey                        start the REPL (or run stdin when piped)
+  monkey run FILE [ARGS...]     run a script file
+  monkey -e EXPR [ARGS...]      evaluate an expression and print the result
+
+Script arguments are available to the program as the array ` + "`args`" + `.
+`
+
 func main() {
-	currentUser, err := user.Current()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run はコマンドライン引数を解釈して実行し、終了コードを返す
+// スクリプトの puts も stdout に書く
+func run(argv []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	expr := flags.String("e", "", "evaluate `EXPR` and print the result")
+	if err := flags.Parse(argv); err != nil {
+		return 2
+	}
+	args := flags.Args()
+
+	ctx := context.Background()
+	interp := monkey.New()
+	renderer := diagnostics.NewRenderer(diagnostics.ColorEnabled(stderr))
+	interp.Stdout = stdout
+
+	switch {
+	case isFlagSet(flags, "e"):
+		return runScript(interp, renderer, stderr, args, func() (object.Object, error) {
+			renderer.AddSource("", *expr)
+			result, err := interp.Eval(ctx, *expr)
+			if err == nil && result.Type() != object.NULL_OBJ {
+				fmt.Fprintln(stdout, result.Inspect())
+			}
+			return result, err
+		})
+	case len(args) > 0 && args[0] == "run":
+		if len(args) < 2 {
+			flags.Usage()
+			return 2
+		}
+		return runScript(interp, renderer, stderr, args[2:], func() (object.Object, error) {
+			source, err := ioutil.ReadFile(args[1])
+			if err != nil {
+				return nil, err
+			}
+			renderer.AddSource(args[1], string(source))
+			return interp.EvalFile(ctx, args[1], string(source))
+		})
+	case len(args) > 0:
+		flags.Usage()
+		return 2
+	case !isTerminal(stdin):
+		return runScript(interp, renderer, stderr, nil, func() (object.Object, error) {
+			source, err := ioutil.ReadAll(stdin)
+			if err != nil {
+				return nil, err
+			}
+			renderer.AddSource("", string(source))
+			return interp.Eval(ctx, string(source))
+		})
+	default:
+		currentUser, err := user.Current()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(stdout, "Hello %s!! This is Monkey REPL!\n", currentUser.Username)
+		repl.Start(stdin, stdout)
+		return 0
+	}
+}
+
+// runScript はスクリプト引数を設定してから eval を呼び、エラーがあれば標準エラー出力に書く
+func runScript(interp *monkey.Interpreter, renderer *diagnostics.Renderer, stderr io.Writer, scriptArgs []string, eval func() (object.Object, error)) int {
+	values := make([]interface{}, 0, len(scriptArgs))
+	for _, a := range scriptArgs {
+		values = append(values, a)
+	}
+	if err := interp.Set("args", values); err != nil {
+		return printError(stderr, renderer, err)
+	}
+
+	if _, err := eval(); err != nil {
+		return printError(stderr, renderer, err)
+	}
+	return 0
+}
+
+// printError は構文エラーと実行時エラーをソースコードと一緒に表示する
+func printError(out io.Writer, renderer *diagnostics.Renderer, err error) int {
+	var parseErr *monkey.ParseError
+	var runtimeErr *monkey.RuntimeError
+
+	switch {
+	case errors.As(err, &parseErr):
+		for _, d := range parseErr.Diagnostics {
+			renderer.Render(out, diagnostics.FromDiagnostic(d))
+		}
+	case errors.As(err, &runtimeErr):
+		renderer.Render(out, diagnostics.FromError(runtimeErr.Err))
+	default:
+		fmt.Fprintf(out, "monkey: %s\n", err)
+	}
+	return 1
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// isTerminal は r が端末に接続されたファイルかどうかを返す
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
 	if err != nil {
-		panic(err)
+		return false
 	}
-	fmt.Printf("Hello %s!! This is Monkey REPL!\n", currentUser.Username)
-	repl.Start(os.Stdin, os.Stdout)
+	return info.Mode()&os.ModeCharDevice != 0
 }
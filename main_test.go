@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "script.monkey")
+	if err := ioutil.WriteFile(script, []byte(`puts(len(args)); puts(args[0]);`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.monkey")
+	if err := ioutil.WriteFile(broken, []byte("let x = 1;\nx + true"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		argv           []string
+		stdin          string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string // 標準エラー出力に含まれる文字列
+	}{
+		{"run file with args", []string{"run", script, "a", "b"}, "", 0, "2\na\n", ""},
+		{"run missing file", []string{"run", filepath.Join(dir, "missing.monkey")}, "", 1, "", "monkey: open "},
+		{"run without file", []string{"run"}, "", 2, "", "Usage:"},
+		{"run runtime error", []string{"run", broken}, "", 1, "", "--> " + broken + ":2:1"},
+		{"expression", []string{"-e", "1 + 2"}, "", 0, "3\n", ""},
+		{"expression with args", []string{"-e", "args", "x", "y"}, "", 0, "[x, y]\n", ""},
+		{"expression with null result", []string{"-e", "let x = 1;"}, "", 0, "", ""},
+		{"expression parse error", []string{"-e", "let = 1;"}, "", 1, "", "error: expected next token to be IDENT"},
+		{"expression runtime error", []string{"-e", "1 + true"}, "", 1, "", "error: type mismatch: INTEGER + BOOLEAN"},
+		{"piped stdin", nil, "puts(1 + 1);\nputs(len(args))", 0, "2\n0\n", ""},
+		{"piped stdin runtime error", nil, "\nfoo", 1, "", "identifier not found: foo"},
+		{"unknown command", []string{"build"}, "", 2, "", "Usage:"},
+		{"unknown flag", []string{"-x"}, "", 2, "", "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.argv, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.expectedCode {
+			t.Errorf("%s: wrong exit code. expected %d, got %d (stderr %q)", tt.name, tt.expectedCode, code, stderr.String())
+		}
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("%s: wrong stdout. expected %q, got %q", tt.name, tt.expectedStdout, stdout.String())
+		}
+		if tt.expectedStderr == "" && stderr.Len() != 0 {
+			t.Errorf("%s: unexpected stderr %q", tt.name, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%s: stderr does not contain %q. got %q", tt.name, tt.expectedStderr, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package monkey は Monkey インタプリタを Go のプログラムに組み込むための API を提供する
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"github.com/atrn0/go-monkey/evaluator"
+	"github.com/atrn0/go-monkey/lexer"
+	"github.com/atrn0/go-monkey/object"
+	"github.com/atrn0/go-monkey/parser"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// ParseError はソースコードの構文エラー
+type ParseError struct {
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		msgs = append(msgs, d.String())
+	}
+	return "parse error: " + strings.Join(msgs, "; ")
+}
+
+// RuntimeError は評価中に発生したエラー
+type RuntimeError struct {
+	Err *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Err.Pos.IsValid() {
+		return fmt.Sprintf("runtime error: %s: %s", e.Err.Pos, e.Err.Message)
+	}
+	return "runtime error: " + e.Err.Message
+}
+
+// Unwrap は評価を中断させた Go のエラーを返す
+// context のキャンセルで中断された場合は errors.Is(err, context.Canceled) が真になる
+func (e *RuntimeError) Unwrap() error { return e.Err.Err }
+
+// Interpreter はグローバル変数を保持し、複数回の評価で共有する
+// 1 つの Interpreter を複数の goroutine から同時に使ってはいけない
+type Interpreter struct {
+	// Config は評価ごとに適用される資源の制限。New では evaluator.DefaultConfig() になる
+	// 制限なしで評価するには evaluator.Config{} を設定する
+	Config evaluator.Config
+	// Stdout はこの Interpreter で評価したスクリプトの puts の出力先
+	// nil の場合は Config.Stdout、それも nil なら os.Stdout に書く
+	Stdout io.Writer
+
+	env *object.Environment
+}
+
+func New() *Interpreter {
+	return &Interpreter{Config: evaluator.DefaultConfig(), env: object.NewEnvironment()}
+}
+
+// Eval は source を評価して最後の式の値を返す
+// 値を持たない文で終わる場合は NULL を返す
+// ctx がキャンセルされると評価を中断して *RuntimeError を返す
+func (i *Interpreter) Eval(ctx context.Context, source string) (object.Object, error) {
+	return i.eval(ctx, "", source)
+}
+
+// Run は filename のファイルを読み込んで評価する
+func (i *Interpreter) Run(ctx context.Context, filename string) (object.Object, error) {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return i.EvalFile(ctx, filename, string(source))
+}
+
+// EvalFile は filename から読み込んだ source を評価する
+// エラーの位置には filename が含まれる
+func (i *Interpreter) EvalFile(ctx context.Context, filename, source string) (object.Object, error) {
+	return i.eval(ctx, filename, source)
+}
+
+func (i *Interpreter) eval(ctx context.Context, filename, source string) (object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Diagnostics: p.Diagnostics()}
+	}
+
+	config := i.Config
+	if i.Stdout != nil {
+		config.Stdout = i.Stdout
+	}
+	result := evaluator.EvalWithConfig(ctx, program, i.env, config)
+	if result == nil {
+		return evaluator.NULL, nil
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Err: errObj}
+	}
+
+	return result, nil
+}
+
+// Set は Go の値を Monkey の値に変換してグローバル変数 name に設定する
+// 変換できる値については ToObject を参照
+func (i *Interpreter) Set(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("set %s: %w", name, err)
+	}
+	if builtin, ok := obj.(*object.Builtin); ok && builtin.Name == "" {
+		// 同じ組み込み関数を別の名前や別の Interpreter に登録できるように、複製に名前を付ける
+		named := *builtin
+		named.Name = name
+		obj = &named
+	}
+	i.env.Set(name, obj)
+	return nil
+}
+
+// Get はグローバル変数 name の値を返す
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// ToObject は Go の値を Monkey の値に変換する
+// nil, bool, 整数, *big.Int, 浮動小数点数, string, []interface{}, map[string]interface{},
+// object.Object と object.BuiltinFunction を変換できる
+func ToObject(value interface{}) (object.Object, error) {
+	switch v := value.(type) {
+	case nil:
+		return evaluator.NULL, nil
+	case object.Object:
+		return v, nil
+	case bool:
+		if v {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+	case int:
+		return &object.Integer{Value: int64(v)}, nil
+	case int32:
+		return &object.Integer{Value: int64(v)}, nil
+	case int64:
+		return &object.Integer{Value: v}, nil
+	case *big.Int:
+		return object.NewInteger(new(big.Int).Set(v)), nil
+	case float32:
+		return &object.Float{Value: float64(v)}, nil
+	case float64:
+		return &object.Float{Value: v}, nil
+	case string:
+		return &object.String{Value: v}, nil
+	case []interface{}:
+		elements := make([]object.Object, 0, len(v))
+		for _, e := range v {
+			obj, err := ToObject(e)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, obj)
+		}
+		return &object.Array{Elements: elements}, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		hash := object.NewHash()
+		for _, k := range keys {
+			obj, err := ToObject(v[k])
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&object.String{Value: k}, obj)
+		}
+		return hash, nil
+	case object.BuiltinFunction:
+		return &object.Builtin{Fn: v}, nil
+	case func(args ...object.Object) object.Object:
+		return &object.Builtin{Fn: v}, nil
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a Monkey value", value)
+	}
+}
@@ -0,0 +1,204 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/atrn0/go-monkey/evaluator"
+	"github.com/atrn0/go-monkey/object"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	interp := New()
+	ctx := context.Background()
+
+	if _, err := interp.Eval(ctx, "let add = fn(x, y) { x + y };"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result, err := interp.Eval(ctx, "add(1, 2)")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Inspect() != "3" {
+		t.Errorf("wrong result. got %s", result.Inspect())
+	}
+
+	result, err = interp.Eval(ctx, "let x = 1;")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Type() != object.NULL_OBJ {
+		t.Errorf("expected NULL. got %s", result.Inspect())
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	interp := New()
+	ctx := context.Background()
+
+	_, err := interp.Eval(ctx, "let = 1;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError. got %T (%v)", err, err)
+	}
+	if len(parseErr.Diagnostics) != 1 {
+		t.Errorf("ParseError has wrong number of diagnostics. got %d", len(parseErr.Diagnostics))
+	}
+
+	_, err = interp.Eval(ctx, "1 + true")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError. got %T (%v)", err, err)
+	}
+	if err.Error() != "runtime error: 1:1: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error message. got %q", err.Error())
+	}
+
+	strict := New()
+	strict.Config.Overflow = evaluator.OverflowError
+	_, err = strict.Eval(ctx, "9223372036854775807 + 1")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.OVERFLOW_ERROR {
+		t.Errorf("expected OverflowError. got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := interp.Eval(canceled, "1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled. got %v", err)
+	}
+
+	// 既定では evaluator.DefaultConfig の制限で止まる
+	_, err = interp.Eval(ctx, "let g = fn(n) { g(n + 1) }; g(0)")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.LIMIT_ERROR {
+		t.Errorf("expected LimitError. got %v", err)
+	}
+
+	// 制限を外しても ctx のタイムアウトで止まる
+	unlimited := New()
+	unlimited.Config = evaluator.Config{}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = unlimited.Eval(timeout, "let f = fn(n) { f(n + 1) + f(n + 1) }; f(0)")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded. got %v", err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	interp := New()
+	ctx := context.Background()
+
+	values := map[string]interface{}{
+		"n":    42,
+		"s":    "monkey",
+		"b":    true,
+		"null": nil,
+		"arr":  []interface{}{1, "two", false},
+		"conf": map[string]interface{}{"limit": 10},
+		"big":  new(big.Int).Lsh(big.NewInt(1), 64),
+		"f":    1.5,
+		"triple": object.BuiltinFunction(func(args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 3}
+		}),
+	}
+	for name, value := range values {
+		if err := interp.Set(name, value); err != nil {
+			t.Fatalf("Set(%q) failed: %s", name, err)
+		}
+	}
+
+	result, err := interp.Eval(ctx, `let out = [n, s, b, null, arr[1], conf["limit"], triple(2), big + 1, f];`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Type() != object.NULL_OBJ {
+		t.Errorf("expected NULL. got %s", result.Inspect())
+	}
+
+	out, ok := interp.Get("out")
+	if !ok {
+		t.Fatalf("out is not defined")
+	}
+	if out.Inspect() != "[42, monkey, true, null, two, 10, 6, 18446744073709551617, 1.5]" {
+		t.Errorf("wrong value. got %s", out.Inspect())
+	}
+
+	if _, ok := interp.Get("undefined"); ok {
+		t.Errorf("Get returned a value for an undefined name")
+	}
+
+	if err := interp.Set("ch", make(chan int)); err == nil {
+		t.Errorf("expected an error for an unsupported value")
+	}
+
+	// 名前の無い組み込み関数は登録した名前で呼ばれるが、元の値は書き換えない
+	shared := &object.Builtin{Fn: func(args ...object.Object) object.Object { return evaluator.NULL }}
+	for _, name := range []string{"first_name", "second_name"} {
+		if err := interp.Set(name, shared); err != nil {
+			t.Fatalf("Set(%q) failed: %s", name, err)
+		}
+		obj, _ := interp.Get(name)
+		if builtin, ok := obj.(*object.Builtin); !ok || builtin.Name != name {
+			t.Errorf("wrong builtin for %q. got %+v", name, obj)
+		}
+	}
+	if shared.Name != "" {
+		t.Errorf("Set modified the builtin. got name %q", shared.Name)
+	}
+}
+
+func TestStdout(t *testing.T) {
+	// Interpreter ごとに puts の出力を受け取れる
+	var wg sync.WaitGroup
+	interps := []*Interpreter{New(), New()}
+	outs := make([]bytes.Buffer, len(interps))
+	for i, interp := range interps {
+		interp.Stdout = &outs[i]
+		wg.Add(1)
+		go func(i int, interp *Interpreter) {
+			defer wg.Done()
+			source := fmt.Sprintf("for (x in range(3)) { puts(%d * 10 + x) }", i)
+			if _, err := interp.Eval(context.Background(), source); err != nil {
+				t.Errorf("interpreter %d: unexpected error: %v", i, err)
+			}
+		}(i, interp)
+	}
+	wg.Wait()
+
+	for i, expected := range []string{"0\n1\n2\n", "10\n11\n12\n"} {
+		if outs[i].String() != expected {
+			t.Errorf("wrong output of interpreter %d. expected %q, got %q", i, expected, outs[i].String())
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "script.monkey")
+	if err := ioutil.WriteFile(filename, []byte("let x = 2;\nx + true"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New().Run(context.Background(), filename)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := "runtime error: " + filename + ":2:1: type mismatch: INTEGER + BOOLEAN"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected %q, got %q", expected, err.Error())
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/atrn0/go-monkey/ast"
+	"github.com/atrn0/go-monkey/token"
+	"hash/fnv"
+	"math/big"
+	"strconv"
 	"strings"
 )
 
@@ -11,11 +15,19 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
+	STRING_OBJ       = "STRING"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+	BUILTIN_OBJ      = "BUILTIN"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	RANGE_OBJ        = "RANGE"
 )
 
 type Object interface {
@@ -30,6 +42,37 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// BigInt は int64 に収まらない整数。言語からは Integer と同じ INTEGER に見える
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return INTEGER_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+
+// NewInteger は v が int64 に収まれば Integer を、収まらなければ BigInt を返す
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect は整数と区別できるよう、整数値の浮動小数点数にも ".0" を付ける
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -37,6 +80,24 @@ type Boolean struct {
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 
+// BuiltinFunction は Go で実装された組み込み関数
+type BuiltinFunction func(args ...Object) Object
+
+type Builtin struct {
+	Name string
+	Fn   BuiltinFunction
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return fmt.Sprintf("builtin function %s", b.Name) }
+
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) Inspect() string  { return s.Value }
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -51,16 +112,73 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
 func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
+// Break はループを抜けることを表す。ReturnValue と同じく評価中にだけ現れる
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+// Continue はループの次の繰り返しに進むことを表す
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Error の種類
+const (
+	ERROR_KIND          = "Error" // throw で投げられた値
+	TYPE_ERROR          = "TypeError"
+	NAME_ERROR          = "NameError"
+	ARGUMENT_ERROR      = "ArgumentError"
+	VALUE_ERROR         = "ValueError"
+	OVERFLOW_ERROR      = "OverflowError"
+	ZERO_DIVISION_ERROR = "ZeroDivisionError"
+	INTERNAL_ERROR      = "InternalError" // インタプリタ内部の panic から回復したもの
+	LIMIT_ERROR         = "LimitError"
+	CANCELED_ERROR      = "CanceledError"
+	SYNTAX_ERROR        = "SyntaxError" // 構文エラーのある AST で欠けている式を評価しようとしたもの
+)
+
 type Error struct {
-	Message string
+	Kind          string // TYPE_ERROR など
+	Message       string
+	Pos           token.Position // エラーが発生したノードの位置
+	End           token.Position // エラーが発生したノードの直後の位置
+	Err           error          // 評価を中断させた Go のエラー (context.Canceled など)
+	Stack         []StackFrame   // エラーが伝わってきた関数呼び出し。内側の呼び出しが先
+	DroppedFrames int            // Stack に記録しきれずに真ん中から捨てた呼び出しの数
+	Value         Object         // throw で投げられた値
+}
+
+// StackFrame はエラーが発生したときの関数呼び出し 1 つ分
+type StackFrame struct {
+	Function string         // let で束縛された関数の名前。無名関数の場合は空
+	Pos      token.Position // 呼び出した位置
+	Args     string         // 引数の要約
+}
+
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+	return fmt.Sprintf("%s(%s) (%s)", name, f.Args, f.Pos)
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
-func (e *Error) Inspect() string { return fmt.Sprintf("ERROR: %s", e.Message) }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("ERROR: %s: %s", e.Pos, e.Message)
+	}
+	return fmt.Sprintf("ERROR: %s", e.Message)
+}
 
 type Function struct {
+	Name       string // let で束縛された名前
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression
+	Rest       *ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -70,10 +188,7 @@ func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
-	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
-	}
+	params := ast.ParameterStrings(f.Parameters, f.Defaults, f.Rest)
 
 	out.WriteString("fn")
 	out.WriteString("(")
@@ -84,3 +199,142 @@ func (f *Function) Inspect() string {
 
 	return out.String()
 }
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+
+func (a *Array) Inspect() string {
+	var out bytes.Buffer
+
+	elements := make([]string, 0, len(a.Elements))
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+// HashKey は Hash のキーとして使うための値
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable は Hash のキーとして使えるオブジェクト
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	// Bytes() は絶対値なので、符号も含めて正と負の値を区別する
+	h.Write(b.Value.Append(nil, 16))
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+	Order []HashKey // 挿入された順のキー
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// Set は key に value を対応させる。既存のキーの場合は順序を変えずに値を上書きする
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		h.Order = append(h.Order, hashKey)
+	}
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+
+// Get は key に対応する値を返す
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return pair.Value, true
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := make([]string, 0, len(h.Order))
+	for _, key := range h.Order {
+		pair := h.Pairs[key]
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Range は Start から End の手前まで Step ずつ増える整数の列
+type Range struct {
+	Start int64
+	End   int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+
+func (r *Range) Inspect() string {
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Len は列の要素数を返す
+// range(-2**63, 2**63 - 1) のように int64 に収まらない要素数もあるので uint64 で数える
+func (r *Range) Len() uint64 {
+	switch {
+	case r.Step > 0 && r.Start < r.End:
+		return (uint64(r.End)-uint64(r.Start)-1)/uint64(r.Step) + 1
+	case r.Step < 0 && r.Start > r.End:
+		return (uint64(r.Start)-uint64(r.End)-1)/-uint64(r.Step) + 1
+	default:
+		return 0
+	}
+}
+
+// At は i 番目の要素を返す。i は Len 未満でなければならない
+func (r *Range) At(i uint64) int64 {
+	return int64(uint64(r.Start) + i*uint64(r.Step))
+}
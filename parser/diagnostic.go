@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/atrn0/go-monkey/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// 診断の種類
+const (
+	CodeUnexpectedToken   = "unexpected-token"
+	CodeNoPrefixParseFn   = "no-prefix-parse-fn"
+	CodeIllegalToken      = "illegal-token"
+	CodeInvalidInteger    = "invalid-integer"
+	CodeInvalidFloat      = "invalid-float"
+	CodeInvalidAssignment = "invalid-assignment"
+	CodeOutsideLoop       = "outside-loop"
+	CodeNestingTooDeep    = "nesting-too-deep"
+	CodeInvalidParameter  = "invalid-parameter"
+	CodeInvalidArgument   = "invalid-argument"
+)
+
+// Diagnostic はパース中に見つかった問題
+type Diagnostic struct {
+	Pos      token.Position
+	End      token.Position
+	Severity Severity
+	Code     string
+	Message  string
+	Expected []token.Type // 期待していたトークン (分かる場合)
+	Got      token.Type   // 実際のトークン
+}
+
+// String は "line:column: message" の形式で返す
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
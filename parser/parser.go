@@ -1,35 +1,64 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"github.com/atrn0/go-monkey/ast"
 	"github.com/atrn0/go-monkey/lexer"
 	"github.com/atrn0/go-monkey/token"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 // precedence
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN     // x = y
+	OR         // ||
+	AND        // &&
 	EQUALS     // ==
 	LESSGRATER // < or >
+	BITOR      // |
+	BITXOR     // ^
+	BITAND     // &
+	SHIFT      // << or >>
 	SUM        // +
 	PRODUCT    // *
 	PREFIX     // -x or !x
+	POWER      // **
 	CALL       // myFunction(x)
+	INDEX      // array[index]
 )
 
 var precedences = map[token.Type]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGRATER,
-	token.GT:       LESSGRATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGRATER,
+	token.GT:              LESSGRATER,
+	token.LT_EQ:           LESSGRATER,
+	token.GT_EQ:           LESSGRATER,
+	token.BIT_OR:          BITOR,
+	token.BIT_XOR:         BITXOR,
+	token.BIT_AND:         BITAND,
+	token.SHIFT_LEFT:      SHIFT,
+	token.SHIFT_RIGHT:     SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -43,25 +72,37 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 
-	errors []string
+	diagnostics []Diagnostic
+	// panicking はエラーの後、次の文の区切りまで読み飛ばす間 true になる
+	panicking bool
+	// loopDepth は現在の関数の中で囲んでいるループの数
+	loopDepth int
+	// nesting は読んでいる文と式のネストの深さ
+	nesting int
 
 	prefixParseFns map[token.Type]prefixParseFn
 	infixParseFns  map[token.Type]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, diagnostics: []Diagnostic{}}
 
 	p.prefixParseFns = make(map[token.Type]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.infixParseFns = make(map[token.Type]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
@@ -71,7 +112,24 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseLogicalExpression)
+	p.registerInfix(token.OR, p.parseLogicalExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
 	// set curToken and peekToken
 	p.nextToken()
@@ -83,6 +141,10 @@ func New(l *lexer.Lexer) *Parser {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	// コメントを残す Lexer を渡されてもコメントは構文に関係しない
+	for p.peekToken.Type == token.COMMENT {
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -100,14 +162,91 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// parseStatement は文を 1 つ読む
+// エラーがあった場合は次の文の区切りまで読み飛ばして nil を返す
+// 外側の文のエラーの後でブロックの中の文を読んだ場合も、外側の文を捨てられるよう読み飛ばすモードを保つ
 func (p *Parser) parseStatement() ast.Statement {
+	var stmt ast.Statement
+	outer := p.panicking
+
+	defer p.leaveNesting()
+	if !p.enterNesting() {
+		p.synchronize()
+		p.panicking = outer
+		return nil
+	}
+
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if s := p.parseLetStatement(); s != nil {
+			stmt = s
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		if s := p.parseReturnStatement(); s != nil {
+			stmt = s
+		}
+	case token.THROW:
+		if s := p.parseThrowStatement(); s != nil {
+			stmt = s
+		}
+	case token.WHILE:
+		if s := p.parseWhileStatement(); s != nil {
+			stmt = s
+		}
+	case token.FOR:
+		if s := p.parseForStatement(); s != nil {
+			stmt = s
+		}
+	case token.BREAK, token.CONTINUE:
+		if s := p.parseLoopControlStatement(); s != nil {
+			stmt = s
+		}
 	default:
-		return p.parseExpressionStatement()
+		if s := p.parseExpressionStatement(); s != nil {
+			stmt = s
+		}
+	}
+
+	if p.panicking {
+		p.synchronize()
+		p.panicking = outer
+		return nil
+	}
+
+	return stmt
+}
+
+// synchronize は ';' か、ブロックを閉じる '}' の手前まで読み飛ばす
+// 途中のブロックは対応する '}' まで含めて読み飛ばす
+// 文が '}' で終わっている場合はそこが文の区切りなので読み飛ばさない
+func (p *Parser) synchronize() {
+	p.panicking = false
+
+	if p.curTokenIs(token.RBRACE) {
+		return
+	}
+
+	depth := 0
+	if p.curTokenIs(token.LBRACE) {
+		depth++
+	}
+
+	for {
+		if depth == 0 && (p.curTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE)) {
+			return
+		}
+		if p.curTokenIs(token.EOF) || p.peekTokenIs(token.EOF) {
+			return
+		}
+
+		p.nextToken()
+
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+		}
 	}
 }
 
@@ -148,12 +287,120 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{
-		Token:      p.curToken,
-		Expression: p.parseExpression(LOWEST),
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	// 式文と同じように、本体の後の ';' は省略できる
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
 	}
 
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+// parseLoopControlStatement は break 文と continue 文を読む
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+
+	if p.loopDepth == 0 {
+		p.report(Diagnostic{
+			Pos:     tok.Pos,
+			End:     tok.End,
+			Code:    CodeOutsideLoop,
+			Message: fmt.Sprintf("%s outside loop", tok.Literal),
+			Got:     tok.Type,
+		})
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
+func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -162,11 +409,32 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.Type) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.report(Diagnostic{
+		Pos:     p.curToken.Pos,
+		End:     p.curToken.End,
+		Code:    CodeNoPrefixParseFn,
+		Message: fmt.Sprintf("no prefix parse function for %s found", t),
+		Got:     t,
+	})
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	defer p.leaveNesting()
+	if !p.enterNesting() {
+		return nil
+	}
+
+	if p.curTokenIs(token.ILLEGAL) {
+		p.report(Diagnostic{
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Code:    CodeIllegalToken,
+			Message: p.illegalTokenMessage(p.curToken),
+			Got:     p.curToken.Type,
+		})
+		return nil
+	}
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
@@ -195,21 +463,88 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	}
 }
 
+// illegalTokenMessage は ILLEGAL トークンの診断メッセージを返す
+// Lexer がその位置で問題を見つけていれば、その理由を使う
+func (p *Parser) illegalTokenMessage(tok token.Token) string {
+	for _, e := range p.l.Errors() {
+		if e.Pos.Offset == tok.Pos.Offset {
+			return e.Message
+		}
+	}
+	return fmt.Sprintf("illegal token %q", tok.Literal)
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{
 		Token: p.curToken,
 	}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	digits, base := integerDigits(p.curToken.Literal)
+	value, err := strconv.ParseInt(digits, base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// int64 に収まらない整数は任意精度の整数にする
+		if v, ok := new(big.Int).SetString(digits, base); ok {
+			return &ast.BigIntegerLiteral{Token: p.curToken, Value: v}
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.report(Diagnostic{
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Code:    CodeInvalidInteger,
+			Message: fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
+			Got:     p.curToken.Type,
+		})
 	}
 
 	lit.Value = value
 	return lit
 }
 
+// integerDigits は整数リテラルから区切りの '_' と接頭辞を除いた数字と、その基数を返す
+// 接頭辞の無いリテラルは先頭が 0 でも 10 進数として扱う
+func integerDigits(literal string) (string, int) {
+	literal = strings.ReplaceAll(literal, "_", "")
+	if len(literal) > 2 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X':
+			return literal[2:], 16
+		case 'o', 'O':
+			return literal[2:], 8
+		case 'b', 'B':
+			return literal[2:], 2
+		}
+	}
+	return literal, 10
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{
+		Token: p.curToken,
+	}
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.curToken.Literal, "_", ""), 64)
+	if err != nil {
+		p.report(Diagnostic{
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Code:    CodeInvalidFloat,
+			Message: fmt.Sprintf("could not parse %q as float", p.curToken.Literal),
+			Got:     p.curToken.Type,
+		})
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	return &ast.StringLiteral{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	exp := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -228,6 +563,24 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Operator: p.curToken.Literal,
 	}
 
+	precedence := p.curPrecedence()
+	if exp.Token.Type == token.POWER {
+		// ** は右結合にする: 2 ** 3 ** 2 == 2 ** (3 ** 2)
+		precedence--
+	}
+	p.nextToken()
+	exp.Right = p.parseExpression(precedence)
+
+	return exp
+}
+
+func (p *Parser) parseLogicalExpression(left ast.Expression) ast.Expression {
+	exp := &ast.LogicalExpression{
+		Token:    p.curToken,
+		Left:     left,
+		Operator: p.curToken.Literal,
+	}
+
 	precedence := p.curPrecedence()
 	p.nextToken()
 	exp.Right = p.parseExpression(precedence)
@@ -235,6 +588,32 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseAssignExpression は右結合で代入式を読む
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	name, ok := left.(*ast.Identifier)
+	if !ok {
+		p.report(Diagnostic{
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Code:    CodeInvalidAssignment,
+			Message: fmt.Sprintf("cannot assign to %s", left),
+			Got:     p.curToken.Type,
+		})
+		return nil
+	}
+
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Name:     name,
+		Operator: p.curToken.Literal,
+	}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+
+	return exp
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{
 		Token: p.curToken,
@@ -288,6 +667,58 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	exp := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	exp.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			exp.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Finally = p.parseBlockStatement()
+	}
+
+	if exp.Catch == nil && exp.Finally == nil {
+		p.report(Diagnostic{
+			Pos:      p.peekToken.Pos,
+			End:      p.peekToken.End,
+			Code:     CodeUnexpectedToken,
+			Message:  fmt.Sprintf("expected catch or finally after try block, got %s instead", p.peekToken.Type),
+			Expected: []token.Type{token.CATCH, token.FINALLY},
+			Got:      p.peekToken.Type,
+		})
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
 
@@ -301,6 +732,10 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken
+	}
+
 	return block
 }
 
@@ -311,7 +746,14 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	// 関数の既定値と本体から外側のループは抜けられない
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = loopDepth }()
+
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -321,19 +763,58 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+// parseFunctionParameters は "x, y = 10, ...rest" の形の仮引数を読んで lit に設定する
+// 既定値を持つ仮引数の後には既定値を持たない仮引数を置けず、...rest は最後にしか置けない
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return true
 	}
 
+	seen := make(map[string]bool)
+	hasDefault := false
 	for {
-		p.nextToken()
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.checkParameterName(lit.Rest, seen) {
+				return false
+			}
+			break
+		}
 
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+		if !p.checkParameterName(ident, seen) {
+			return false
+		}
+
+		var defaultValue ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			defaultValue = p.parseExpression(LOWEST)
+			hasDefault = true
+		} else if hasDefault {
+			p.report(Diagnostic{
+				Pos:     ident.Pos(),
+				End:     ident.End(),
+				Code:    CodeInvalidParameter,
+				Message: fmt.Sprintf("parameter %s without a default value follows a parameter with one", ident.Value),
+				Got:     ident.Token.Type,
+			})
+			return false
+		}
+
+		lit.Parameters = append(lit.Parameters, ident)
+		lit.Defaults = append(lit.Defaults, defaultValue)
 
 		if !p.peekTokenIs(token.COMMA) {
 			break
@@ -341,43 +822,167 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		p.nextToken()
 	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
-	}
+	return p.expectPeek(token.RPAREN)
+}
 
-	return identifiers
+// checkParameterName は同じ名前の仮引数が無いことを確かめる
+func (p *Parser) checkParameterName(ident *ast.Identifier, seen map[string]bool) bool {
+	if seen[ident.Value] {
+		p.report(Diagnostic{
+			Pos:     ident.Pos(),
+			End:     ident.End(),
+			Code:    CodeInvalidParameter,
+			Message: fmt.Sprintf("duplicate parameter %s", ident.Value),
+			Got:     ident.Token.Type,
+		})
+		return false
+	}
+	seen[ident.Value] = true
+	return true
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseCallArguments()
+	if p.parseCallArguments(exp) {
+		exp.Rparen = p.curToken
+	}
 	return exp
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	args := []ast.Expression{}
+// parseCallArguments は位置引数と、それに続く name: value の名前付き引数を読む
+func (p *Parser) parseCallArguments(exp *ast.CallExpression) bool {
+	exp.Arguments = []ast.Expression{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return args
+		return true
 	}
 
+	seen := make(map[string]bool)
 	for {
 		p.nextToken()
 
-		args = append(args, p.parseExpression(LOWEST))
+		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.COLON) {
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if seen[name.Value] {
+				p.report(Diagnostic{
+					Pos:     name.Pos(),
+					End:     name.End(),
+					Code:    CodeInvalidArgument,
+					Message: fmt.Sprintf("duplicate named argument %s", name.Value),
+					Got:     name.Token.Type,
+				})
+				return false
+			}
+			seen[name.Value] = true
+
+			p.nextToken()
+			p.nextToken()
+			exp.NamedArguments = append(exp.NamedArguments, &ast.NamedArgument{
+				Name:  name,
+				Value: p.parseExpression(LOWEST),
+			})
+		} else {
+			if len(exp.NamedArguments) > 0 {
+				p.report(Diagnostic{
+					Pos:     p.curToken.Pos,
+					End:     p.curToken.End,
+					Code:    CodeInvalidArgument,
+					Message: "positional argument follows named argument",
+					Got:     p.curToken.Type,
+				})
+				return false
+			}
+			exp.Arguments = append(exp.Arguments, p.parseExpression(LOWEST))
+		}
+
 		if !p.peekTokenIs(token.COMMA) {
 			break
 		}
+		p.nextToken()
+	}
+
+	return p.expectPeek(token.RPAREN)
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.curTokenIs(token.RBRACKET) {
+		array.Rbracket = p.curToken
+	}
+	return array
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	exp.Rbracket = p.curToken
+
+	return exp
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken, Pairs: make(map[ast.Expression]ast.Expression)}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
 
 		p.nextToken()
+		value := p.parseExpression(LOWEST)
+
+		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hash.Rbrace = p.curToken
+
+	return hash
+}
+
+// parseExpressionList は end までのカンマ区切りの式を読む
+func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
+	list := []ast.Expression{}
+
+	if p.peekTokenIs(end) {
+		p.nextToken()
+		return list
+	}
+
+	for {
+		p.nextToken()
+
+		list = append(list, p.parseExpression(LOWEST))
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+
+		p.nextToken()
+	}
+
+	if !p.expectPeek(end) {
 		return nil
 	}
 
-	return args
+	return list
 }
 
 func (p *Parser) curTokenIs(t token.Type) bool {
@@ -398,13 +1003,63 @@ func (p *Parser) expectPeek(t token.Type) bool {
 	}
 }
 
+// Errors は診断を "line:column: message" の形式の文字列で返す
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.String())
+	}
+	return errors
+}
+
+// Diagnostics はパース中に見つかった問題を見つかった順に返す
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) peekError(t token.Type) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.report(Diagnostic{
+		Pos:      p.peekToken.Pos,
+		End:      p.peekToken.End,
+		Code:     CodeUnexpectedToken,
+		Message:  fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type),
+		Expected: []token.Type{t},
+		Got:      p.peekToken.Type,
+	})
+}
+
+// report は診断を追加して、次の文まで読み飛ばすモードに入る
+// 読み飛ばしている間の診断は連鎖したものなので捨てる
+func (p *Parser) report(d Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// maxNesting は文と式のネストの上限
+// 深くネストした入力で Go のスタックを使い果たさないようにする
+const maxNesting = 10000
+
+// enterNesting はネストを 1 段深くし、上限を超えたら診断を報告して false を返す
+func (p *Parser) enterNesting() bool {
+	p.nesting++
+	if p.nesting > maxNesting {
+		p.report(Diagnostic{
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Code:    CodeNestingTooDeep,
+			Message: fmt.Sprintf("too deeply nested (maximum depth %d)", maxNesting),
+			Got:     p.curToken.Type,
+		})
+		return false
+	}
+	return true
+}
+
+func (p *Parser) leaveNesting() {
+	p.nesting--
 }
 
 func (p *Parser) registerPrefix(tokenType token.Type, fn prefixParseFn) {
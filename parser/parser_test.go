@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/atrn0/go-monkey/ast"
 	"github.com/atrn0/go-monkey/lexer"
+	"github.com/atrn0/go-monkey/token"
+	"strings"
 	"testing"
 )
 
@@ -173,6 +175,96 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "1.25e2;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got %T", stmt.Expression)
+	}
+
+	if literal.Value != 125 {
+		t.Errorf("literal.Value not %v. got %v", 125.0, literal.Value)
+	}
+	if literal.TokenLiteral() != "1.25e2" {
+		t.Errorf("literal.TokenLiteral not %q. got %q", "1.25e2", literal.TokenLiteral())
+	}
+}
+
+func TestNumericLiteralForms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"0xff", 255},
+		{"0XfF", 255},
+		{"0o17", 15},
+		{"0b101", 5},
+		{"1_000_000", 1000000},
+		{"0x_7fff_ffff_ffff_ffff", 9223372036854775807},
+		{"1_000.5", 1000.5},
+		{"1e1_0", 1e10},
+		{"9223372036854775808", "9223372036854775808"},
+		{"0xffff_ffff_ffff_ffff", "18446744073709551615"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		switch expected := tt.expected.(type) {
+		case int:
+			literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok || literal.Value != int64(expected) {
+				t.Errorf("%q - expected integer %d. got %s", tt.input, expected, stmt.Expression)
+			}
+		case float64:
+			literal, ok := stmt.Expression.(*ast.FloatLiteral)
+			if !ok || literal.Value != expected {
+				t.Errorf("%q - expected float %v. got %s", tt.input, expected, stmt.Expression)
+			}
+		case string:
+			// int64 に収まらない整数
+			literal, ok := stmt.Expression.(*ast.BigIntegerLiteral)
+			if !ok || literal.Value.String() != expected {
+				t.Errorf("%q - expected big integer %s. got %T", tt.input, expected, stmt.Expression)
+			}
+		}
+		// 元の書き方はトークンに残る
+		if stmt.Expression.TokenLiteral() != tt.input {
+			t.Errorf("TokenLiteral wrong. expected %q, got %q", tt.input, stmt.Expression.TokenLiteral())
+		}
+	}
+}
+
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello world";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.StringLiteral. got %T", stmt.Expression)
+	}
+
+	if literal.Value != "hello world" {
+		t.Errorf("literal.Value not %q. got %q", "hello world", literal.Value)
+	}
+}
+
 func TestPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -305,6 +397,30 @@ func TestOperationPrecedence(t *testing.T) {
 		{"1 / (6 + 6)", "(1 / (6 + 6))"},
 		{"-(4 + 7)", "(-(4 + 7))"},
 		{"!(!true != true)", "(!((!true) != true))"},
+		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
+		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"x = y = 1 + 2", "(x = (y = (1 + 2)))"},
+		{"x += y * 2 == z", "(x += ((y * 2) == z))"},
+		{"x -= 1", "(x -= 1)"},
+		{"x *= 1", "(x *= 1)"},
+		{"x /= 1", "(x /= 1)"},
+		{"a && b || c", "((a && b) || c)"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a == b && c < d", "((a == b) && (c < d))"},
+		{"!a || b", "((!a) || b)"},
+		{"x = a || b", "(x = (a || b))"},
+		{"a <= b == c >= d", "((a <= b) == (c >= d))"},
+		{"a + b % c", "(a + (b % c))"},
+		{"a ** b ** c", "(a ** (b ** c))"},
+		{"-a ** b", "(-(a ** b))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"a ** -b", "(a ** (-b))"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"a & b << c + d", "(a & (b << (c + d)))"},
+		{"a & 1 == 0", "((a & 1) == 0)"},
+		{"a < b | c", "(a < (b | c))"},
+		{"~a & b", "((~a) & b)"},
+		{"a && b | c", "(a && (b | c))"},
 	}
 
 	for _, tt := range tests {
@@ -534,6 +650,124 @@ func TestFunctionParameters(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		rest     string
+	}{
+		{"fn(x, y = 10) { x + y }", "fn(x, y = 10)(x + y)", ""},
+		{"fn(x = 1 + 2, y = x) { y }", "fn(x = (1 + 2), y = x)y", ""},
+		{"fn(first, ...rest) { rest }", "fn(first, ...rest)rest", "rest"},
+		{"fn(...args) { args }", "fn(...args)args", "args"},
+		{"fn(x, y = 2, ...more) { more }", "fn(x, y = 2, ...more)more", "more"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function := stmt.Expression.(*ast.FunctionLiteral)
+
+		if function.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, function.String())
+		}
+		if len(function.Defaults) != len(function.Parameters) {
+			t.Errorf("Defaults and Parameters have different lengths. got %d and %d",
+				len(function.Defaults), len(function.Parameters))
+		}
+		if tt.rest == "" && function.Rest != nil {
+			t.Errorf("unexpected rest parameter %s", function.Rest)
+		}
+		if tt.rest != "" && (function.Rest == nil || function.Rest.Value != tt.rest) {
+			t.Errorf("rest parameter is not %s. got %v", tt.rest, function.Rest)
+		}
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x = 1, y) { }", "1:11: parameter y without a default value follows a parameter with one"},
+		{"fn(x, x) { }", "1:7: duplicate parameter x"},
+		{"fn(x, ...x) { }", "1:10: duplicate parameter x"},
+		{"fn(...rest, x) { }", "1:11: expected next token to be ), got , instead"},
+		{"fn(0) { }", "1:4: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.expected {
+			t.Errorf("wrong errors for %q. expected %q, got %q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   string
+		positional int
+		named      []string
+	}{
+		{"f(limit: 10)", "f(limit: 10)", 0, []string{"limit"}},
+		{"f(1, limit: 10, offset: a + b)", "f(1, limit: 10, offset: (a + b))", 1, []string{"limit", "offset"}},
+		{`f({"a": 1}, opts: {"b": 2})`, `f({"a": 1}, opts: {"b": 2})`, 1, []string{"opts"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		call := stmt.Expression.(*ast.CallExpression)
+
+		if call.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, call.String())
+		}
+		if len(call.Arguments) != tt.positional {
+			t.Errorf("wrong number of positional arguments. want %d, got %d", tt.positional, len(call.Arguments))
+		}
+		if len(call.NamedArguments) != len(tt.named) {
+			t.Fatalf("wrong number of named arguments. want %d, got %d", len(tt.named), len(call.NamedArguments))
+		}
+		for i, name := range tt.named {
+			if call.NamedArguments[i].Name.Value != name {
+				t.Errorf("NamedArguments[%d] is not %s. got %s", i, name, call.NamedArguments[i].Name)
+			}
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(a: 1, 2)", "1:9: positional argument follows named argument"},
+		{"f(a: 1, a: 2)", "1:9: duplicate named argument a"},
+	}
+
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.expected {
+			t.Errorf("wrong errors for %q. expected %q, got %q", tt.input, tt.expected, errors)
+		}
+		if len(errors) == 1 && p.Diagnostics()[0].Code != CodeInvalidArgument {
+			t.Errorf("wrong code. got %s", p.Diagnostics()[0].Code)
+		}
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := `add(1, 2, 3 + 5, 3 / 5)`
 
@@ -601,6 +835,85 @@ func TestCallArguments(t *testing.T) {
 	}
 }
 
+func TestParsingArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.ArrayLiteral. got %T", stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) not 3. got %d", len(array.Elements))
+	}
+
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got %T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+
+	if !testInfixExpression(t, indexExp.Index, 1, "+", 1) {
+		return
+	}
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{}`, `{}`},
+		{`{"one": 1, "two": 2, "three": 3}`, `{"one": 1, "two": 2, "three": 3}`},
+		{`{1: true, true: "x",}`, `{1: true, true: "x"}`},
+		{`{"one": 0 + 1, "two": 10 - 8}`, `{"one": (0 + 1), "two": (10 - 8)}`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		hash, ok := stmt.Expression.(*ast.HashLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.HashLiteral. got %T", stmt.Expression)
+		}
+
+		if len(hash.Pairs) != len(hash.Keys) {
+			t.Errorf("hash.Pairs and hash.Keys differ. got %d and %d", len(hash.Pairs), len(hash.Keys))
+		}
+
+		if hash.String() != tt.expected {
+			t.Errorf("hash.String() wrong. expected %q, got %q", tt.expected, hash.String())
+		}
+	}
+}
+
 func testLiteralExpression(t *testing.T, exp ast.Expression, expected interface{}) bool {
 	switch v := expected.(type) {
 	case int:
@@ -688,3 +1001,353 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestNodePosition(t *testing.T) {
+	input := "let add = fn(x, y) {\n  x + y;\n};\nadd(1, 2)"
+
+	l := lexer.NewFile("test.monkey", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements. got %d", len(program.Statements))
+	}
+
+	tests := []struct {
+		node        ast.Node
+		expectedPos string
+		expectedEnd string
+	}{
+		{program, "test.monkey:1:1", "test.monkey:4:10"},
+		{program.Statements[0], "test.monkey:1:1", "test.monkey:3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "test.monkey:1:11", "test.monkey:3:2"},
+		{program.Statements[1], "test.monkey:4:1", "test.monkey:4:10"},
+	}
+
+	for i, tt := range tests {
+		if pos := tt.node.Pos().String(); pos != tt.expectedPos {
+			t.Errorf("tests[%d] - Pos() wrong. expected %q, got %q", i, tt.expectedPos, pos)
+		}
+		if end := tt.node.End().String(); end != tt.expectedEnd {
+			t.Errorf("tests[%d] - End() wrong. expected %q, got %q", i, tt.expectedEnd, end)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"1 +\n  ;", "2:3: no prefix parse function for ; found"},
+		{"let x = @;", `1:9: illegal token "@"`},
+		{"let x = 0x;", "1:9: hexadecimal literal has no digits"},
+		{"let x = 1__0;", "1:9: '_' must separate successive digits"},
+		{"let x = 0b12;", "1:9: invalid digit '2' in binary literal"},
+		{"let x = 010;", "1:9: leading zeros in decimal integer literal (use 0o for octal)"},
+		{"let x = 0_10;", "1:9: leading zeros in decimal integer literal (use 0o for octal)"},
+		{`let x = "open`, "1:9: unterminated string literal"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. expected %q, got %q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "oops";`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement. got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got %T", program.Statements[0])
+	}
+
+	if stmt.String() != `throw "oops";` {
+		t.Errorf("stmt.String() wrong. got %q", stmt.String())
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   string
+		hasParam   bool
+		hasCatch   bool
+		hasFinally bool
+	}{
+		{"try { x } catch (e) { e }", "try x catch(e) e", true, true, false},
+		{"try { x } catch { 1 }", "try x catch 1", false, true, false},
+		{"try { x } finally { y }", "try x finally y", false, false, true},
+		{"try { x } catch (e) { e } finally { y }", "try x catch(e) e finally y", true, true, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.TryExpression. got %T", stmt.Expression)
+		}
+
+		if exp.String() != tt.expected {
+			t.Errorf("exp.String() wrong. expected %q, got %q", tt.expected, exp.String())
+		}
+		if (exp.CatchParam != nil) != tt.hasParam {
+			t.Errorf("wrong CatchParam for %q. got %v", tt.input, exp.CatchParam)
+		}
+		if (exp.Catch != nil) != tt.hasCatch {
+			t.Errorf("wrong Catch for %q. got %v", tt.input, exp.Catch)
+		}
+		if (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong Finally for %q. got %v", tt.input, exp.Finally)
+		}
+	}
+
+	p := New(lexer.New("try { x }; 1"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "1:10: expected catch or finally after try block, got ; instead" {
+		t.Errorf("wrong errors. got %q", p.Errors())
+	}
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	p := New(lexer.New("1 = 2; f() += 1;"))
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics. got %q", p.Errors())
+	}
+
+	expected := []string{"1:3: cannot assign to 1", "1:12: cannot assign to f()"}
+	for i, d := range diagnostics {
+		if d.Code != CodeInvalidAssignment {
+			t.Errorf("diagnostics[%d] - wrong code. got %s", i, d.Code)
+		}
+		if d.String() != expected[i] {
+			t.Errorf("diagnostics[%d] - expected %q, got %q", i, expected[i], d.String())
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x += 1 }", "while(x < 10) (x += 1)"},
+		{"for (x in [1, 2]) { puts(x) }", "for(x in [1, 2]) puts(x)"},
+		{"while (true) { break; }", "whiletrue break;"},
+		{"for (x in xs) { if (x) { continue; } }", "for(x in xs) ifx continue;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not 1 statement. got %d", len(program.Statements))
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, actual)
+		}
+	}
+
+	// if 式や関数リテラルと同じく、本体の後に ';' を書ける
+	for _, input := range []string{"while (false) {}; 1", "for (x in [1]) {}; 1"} {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 2 {
+			t.Errorf("program has not 2 statements for %q. got %d", input, len(program.Statements))
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"if (true) { continue; }", "1:13: continue outside loop"},
+		{"while (true) { let f = fn() { break; }; }", "1:31: break outside loop"},
+		{"while (true) { fn(x = if (true) { break }) { x }() }", "1:35: break outside loop"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Errorf("expected 1 diagnostic for %q. got %q", tt.input, p.Errors())
+			continue
+		}
+		if diagnostics[0].Code != CodeOutsideLoop {
+			t.Errorf("wrong code. got %s", diagnostics[0].Code)
+		}
+		if diagnostics[0].String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, diagnostics[0].String())
+		}
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("(", maxNesting+1) + "1" + strings.Repeat(")", maxNesting+1),
+		strings.Repeat("while (true) { ", maxNesting+1) + strings.Repeat("}", maxNesting+1),
+	}
+
+	for _, input := range inputs {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("expected a diagnostic for deeply nested input")
+			continue
+		}
+		if diagnostics[0].Code != CodeNestingTooDeep {
+			t.Errorf("wrong code. got %s: %s", diagnostics[0].Code, diagnostics[0])
+		}
+	}
+
+	p := New(lexer.New(strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100)))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestComments(t *testing.T) {
+	input := `#!/usr/bin/env monkey
+// 合計を求める
+let sum = fn(a, /* 既定値 */ b = 1) {
+	a + b // 足す
+};
+sum(1 /* , 2 */)`
+
+	for _, keep := range []bool{false, true} {
+		l := lexer.New(input)
+		l.KeepComments = keep
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		expected := "let sum = fn(a, b = 1)(a + b);sum(1)"
+		if program.String() != expected {
+			t.Errorf("KeepComments=%t: expected %q, got %q", keep, expected, program.String())
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let = 5;
+let x = 1;
+let f = fn(a) {
+	let y = ;
+	a + y
+};
+add(1, 2;
+let z = x + 1;
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expected := []struct {
+		pos      string
+		code     string
+		expected []token.Type
+		got      token.Type
+	}{
+		{"1:5", CodeUnexpectedToken, []token.Type{token.IDENT}, token.ASSIGN},
+		{"4:10", CodeNoPrefixParseFn, nil, token.SEMICOLON},
+		{"7:9", CodeUnexpectedToken, []token.Type{token.RPAREN}, token.SEMICOLON},
+	}
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want %d, got %d: %q",
+			len(expected), len(diagnostics), p.Errors())
+	}
+
+	for i, tt := range expected {
+		d := diagnostics[i]
+		if d.Pos.String() != tt.pos {
+			t.Errorf("diagnostics[%d] - wrong position. want %s, got %s", i, tt.pos, d.Pos)
+		}
+		if d.Severity != SeverityError {
+			t.Errorf("diagnostics[%d] - wrong severity. got %s", i, d.Severity)
+		}
+		if d.Code != tt.code {
+			t.Errorf("diagnostics[%d] - wrong code. want %s, got %s", i, tt.code, d.Code)
+		}
+		if fmt.Sprint(d.Expected) != fmt.Sprint(tt.expected) {
+			t.Errorf("diagnostics[%d] - wrong expected tokens. want %v, got %v", i, tt.expected, d.Expected)
+		}
+		if d.Got != tt.got {
+			t.Errorf("diagnostics[%d] - wrong got token. want %s, got %s", i, tt.got, d.Got)
+		}
+	}
+
+	// エラーの無い文は部分的な AST として残る
+	if actual := program.String(); actual != "let x = 1;let f = fn(a)(a + y);let z = (x + 1);" {
+		t.Errorf("wrong partial program. got %q", actual)
+	}
+}
+
+func TestErrorRecoveryInNestedStatements(t *testing.T) {
+	// 外側の文のエラーはブロックの中の文を読んだ後でも外側の文ごと捨てる
+	tests := []struct {
+		input    string
+		errors   int
+		expected string
+	}{
+		{"if (-#) { 1 }", 1, ""},
+		{"if (-#) { 1 }\nlet y = 2;", 1, "let y = 2;"},
+		{"let x = 1 + #; if (x) { x }", 1, "ifx x"},
+		{"while (a[#]) { let b = 1; } 3", 1, "3"},
+		{"for (x in f(#)) { x } 4", 1, "4"},
+		{"try { 1 } catch (e) { e } + #; 5", 1, "5"},
+		{"if (x) { let = 1; 2 }", 1, "ifx 2"},
+		{"fn() { if (-#) { 1 }; 2 }", 1, "fn()2"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if len(p.Errors()) != tt.errors {
+			t.Errorf("%q: wrong number of errors. want %d, got %q", tt.input, tt.errors, p.Errors())
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q: wrong partial program. want %q, got %q", tt.input, tt.expected, actual)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"github.com/atrn0/go-monkey/diagnostics"
 	"github.com/atrn0/go-monkey/evaluator"
 	"github.com/atrn0/go-monkey/lexer"
 	"github.com/atrn0/go-monkey/object"
@@ -15,24 +17,37 @@ const PROMPT = ">> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
-	for {
+	renderer := diagnostics.NewRenderer(diagnostics.ColorEnabled(out))
+	config := evaluator.DefaultConfig()
+	config.Stdout = out
+
+	for n := 1; ; n++ {
 		fmt.Printf(PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
 		}
 
+		// 以前に入力した関数のエラーも表示できるように、入力ごとに名前を付けて残しておく
+		filename := fmt.Sprintf("<repl:%d>", n)
 		line := scanner.Text()
-		l := lexer.New(line)
+		renderer.AddSource(filename, line)
+
+		l := lexer.NewFile(filename, line)
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+		if len(p.Diagnostics()) != 0 {
+			printParseErrors(out, renderer, p.Diagnostics())
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		// 制限は入力ごとに数える
+		evaluated := evaluator.EvalWithConfig(context.Background(), program, env, config)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			renderer.Render(out, diagnostics.FromError(errObj))
+			continue
+		}
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -40,10 +55,8 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t")
-		io.WriteString(out, msg)
-		io.WriteString(out, "\n")
+func printParseErrors(out io.Writer, renderer *diagnostics.Renderer, errors []parser.Diagnostic) {
+	for _, d := range errors {
+		renderer.Render(out, diagnostics.FromDiagnostic(d))
 	}
 }
@@ -0,0 +1,33 @@
+package token
+
+import "fmt"
+
+// Position はソースコード上の位置を表す
+// Line, Column, ByteColumn は 1 始まり、Offset は 0 始まりのバイトオフセット
+// Column は行頭からの文字 (rune) 数、ByteColumn は行頭からのバイト数で数える
+type Position struct {
+	Filename   string
+	Offset     int
+	Line       int
+	Column     int
+	ByteColumn int
+}
+
+// IsValid は位置情報が設定されているかどうかを返す
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String は "file:line:column" の形式で位置を返す
+// ファイル名が無い場合は "line:column" になる
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}